@@ -0,0 +1,41 @@
+package mmbot
+
+import (
+	"github.com/mattermost/platform/model"
+)
+
+// Adapter is the connection between BotKit and a chat server.
+type Adapter interface {
+	// Connect logs in to the server and returns the bot user and its team.
+	Connect() (*model.User, *model.Team, error)
+
+	// Listen starts receiving events. The returned channel is closed when the connection drops.
+	Listen() (<-chan *model.WebSocketEvent, error)
+
+	// Ping checks the server is still reachable and still sends the events.
+	Ping() error
+
+	// Close stops listening to events.
+	Close()
+
+	GetChannels() ([]*model.Channel, error)
+	GetChannel(channelId string) (*model.Channel, error)
+	GetChannelByName(channelName string) (*model.Channel, error)
+	GetDirectChannel(userId string) (*model.Channel, error)
+	GetUser(userId string) (*model.User, error)
+
+	// GetPostsSince returns the posts created in the channel after the time in milliseconds, oldest first.
+	GetPostsSince(channelId string, since int64) ([]*model.Post, error)
+
+	GetPost(channelId, postId string) (*model.Post, error)
+	CreatePost(post *model.Post) (*model.Post, error)
+
+	// UpdatePost replaces the message of the post with the id of the given one.
+	UpdatePost(post *model.Post) (*model.Post, error)
+	DeletePost(channelId, postId string) error
+
+	SaveReaction(channelId string, reaction *model.Reaction) error
+	DeleteReaction(channelId string, reaction *model.Reaction) error
+
+	PostToWebhook(webhook, payload string) error
+}
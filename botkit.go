@@ -1,33 +1,70 @@
 package mmbot
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
-	"net/url"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 
 	"github.com/joho/godotenv"
 	"github.com/mattermost/platform/model"
 )
 
+// Helper is implemented by plugins describing their commands, such as the ones built on Router.
+type Helper interface {
+	Help() []HelpEntry
+}
+
+// Plugin is the original plugin interface. New plugins should implement Handler instead.
 type Plugin interface {
 	HandleMessage(string, string, string) error
 	Usage() string
 }
 
 type BotKit struct {
-	client  *model.Client
-	plugins []Plugin
-	webhook string
-
-	User     *model.User
-	Team     *model.Team
-	Channels []*model.Channel
-	Memory   *Memory
+	adapter       Adapter
+	plugins       []Handler
+	pluginsMu     sync.RWMutex
+	failures      map[Handler]error
+	initialized   map[Handler]bool
+	running       map[Handler]bool
+	pluginsCtx    context.Context
+	pendingStart  []Handler
+	listeners     []Listener
+	subscriptions map[string][]*subscription
+	subsClosed    bool
+	hearLimiter   hearLimiter
+	mentions      mentionPatterns
+	queue         *queue
+	outboxMu      sync.Mutex
+	outboxSeq     int64
+	stopQueue     context.CancelFunc
+	started       sync.Once
+	middlewares   []Middleware
+	config        *Config
+	configMu      sync.RWMutex
+	reloadMu      sync.Mutex
+
+	ctx      context.Context
+	inflight sync.WaitGroup
+
+	stateMu        sync.Mutex
+	state          ConnectionState
+	stateListeners []func(ConnectionState)
+
+	channels   map[string]*model.Channel
+	channelsMu sync.RWMutex
+
+	userCache    *cache
+	channelCache *cache
+
+	User   *model.User
+	Team   *model.Team
+	Memory *Memory
 }
 
 func NewBotKit() *BotKit {
@@ -35,249 +72,245 @@ func NewBotKit() *BotKit {
 		log.Println("Error loading .env file")
 	}
 
-	webhook := os.Getenv("MMBOT_WEBHOOK")
-	account := os.Getenv("MMBOT_ACCOUNT")
-	password := os.Getenv("MMBOT_PASSWORD")
-	teamname := os.Getenv("MMBOT_TEAMNAME")
-	endpoint := os.Getenv("MMBOT_ENDPOINT")
-
-	b := new(BotKit)
-	b.client = model.NewClient(endpoint)
-	b.plugins = []Plugin{}
-	b.webhook = webhook
-
-	// open leveldb
-	if memory, err := NewMemory(); err != nil {
-		log.Fatalf("We failed to open level db: %v", err.Error())
-	} else {
-		b.Memory = memory
-	}
-
-	// confirm the mattermost server is alive
-	if props, err := b.client.GetPing(); err != nil {
-		log.Fatalf("There was a problem pinging the Mattermost server '%s': %v\n", endpoint, err.Error())
-	} else {
-		log.Printf("Server detected and is running version %s\n", props["version"])
+	config, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("%v\n", err.Error())
 	}
-
-	// login to the mattermost server
-	if result, err := b.client.Login(account, password); err != nil {
-		log.Fatalf("There was a problem logging into the Mattermost server: %v\n", err.Error())
-	} else {
-		b.User = result.Data.(*model.User)
+	if err := config.validateServer(); err != nil {
+		log.Fatalf("%v\n", err.Error())
 	}
 
-	// login to the mattermost team
-	if result, err := b.client.GetInitialLoad(); err != nil {
-		log.Fatalf("We failed to get the initial load: %v\n", err.Error())
-	} else {
-		initialLoad := result.Data.(*model.InitialLoad)
-		for _, team := range initialLoad.Teams {
-			if team.Name == teamname {
-				b.Team = team
-				break
-			}
-		}
-
-		if b.Team == nil {
-			log.Fatalf("We do not appear to be a member of the team '%s'\n", teamname)
-		}
-
-		b.client.SetTeamId(b.Team.Id)
-	}
+	return NewBotKitWithConfig(config.newAdapter(), config)
+}
 
-	// join to the mattermost channel
-	if result, err := b.getChannels(); err != nil {
-		log.Fatalf("We failed to get the bot channels: %v", err.Error())
-	} else {
-		b.Channels = result.Data.([]*model.Channel)
-		for _, channel := range b.Channels {
-			log.Printf("Join the channel '%s'\n", channel.Name)
-		}
+func NewBotKitWithAdapter(adapter Adapter) *BotKit {
+	config, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("%v\n", err.Error())
 	}
 
-	return b
+	return NewBotKitWithConfig(adapter, config)
 }
 
-func (b *BotKit) SendMessage(text, channel, username, iconUrl string) error {
-	// if the webhook id is not specified, bot will try to send message with api driver
-	if b.webhook == "" {
-		log.Println("Incoming Webhook ID is not set. Try to send message with API driver.")
-
-		var ch *model.Channel
-		if result, err := b.client.GetChannelByName(channel); err != nil {
-			return fmt.Errorf("Channel '%s' is not found", channel)
-		} else {
-			ch = result.Data.(*model.Channel)
-		}
+func NewBotKitWithConfig(adapter Adapter, config *Config) *BotKit {
+	b := new(BotKit)
+	b.adapter = adapter
+	b.config = config
+	b.running = map[Handler]bool{}
+	b.initialized = map[Handler]bool{}
 
-		post := &model.Post{Message: text, ChannelId: ch.Id}
-		return b.SendMessageWithAPI(post)
+	if level, err := ParseLogLevel(config.LogLevel); err == nil {
+		SetLogLevel(level)
 	}
+	b.plugins = []Handler{}
+	b.failures = map[Handler]error{}
+	b.channels = map[string]*model.Channel{}
+	b.userCache = newCache(CACHE_TTL, CACHE_SIZE)
+	b.channelCache = newCache(CACHE_TTL, CACHE_SIZE)
+	b.ctx = context.Background()
+	b.queue = newQueue()
 
-	message := map[string]string{"text": text, "channel": channel}
-
-	if username != "" {
-		message["username"] = username
+	// open leveldb
+	if memory, err := NewMemoryWithPath(config.LevelDBPath); err != nil {
+		log.Fatalf("We failed to open level db: %v", err.Error())
 	} else {
-		message["username"] = b.User.Username
+		b.Memory = memory
 	}
 
-	if iconUrl != "" {
-		message["icon_url"] = iconUrl
+	// login to the chat server
+	if user, team, err := b.adapter.Connect(); err != nil {
+		log.Fatalf("%v\n", err.Error())
+	} else {
+		b.User = user
+		b.Team = team
 	}
 
-	// send message with incoming webhook
-	payload, _ := json.Marshal(message)
-	content := fmt.Sprintf("payload=%s", string(payload))
-	if _, err := b.client.PostToWebhook(b.webhook, content); err != nil {
-		return fmt.Errorf("We failed to send a message: %v", payload, err.Error())
+	// join to the mattermost channel
+	if err := b.refreshChannels(); err != nil {
+		log.Fatalf("We failed to get the bot channels: %v", err.Error())
 	}
 
-	return nil
-}
-
-func (b *BotKit) SendMessageWithAPI(post *model.Post) error {
-	// send message with api driver
-	if _, err := b.client.CreatePost(post); err != nil {
-		return fmt.Errorf("We failed to send a message with api driver: %v", err.Error())
-	}
+	// send the queued messages, including the ones left when the bot stopped
+	b.resendOutbox()
+	queueCtx, stopQueue := context.WithCancel(context.Background())
+	b.stopQueue = stopQueue
+	go b.runQueue(queueCtx)
 
-	return nil
+	return b
 }
 
 func (b *BotKit) Run() {
-	wsUrl, _ := url.Parse(b.client.Url)
-	wsUrl.Scheme = "ws"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	// create websocket
-	wsClient, err := model.NewWebSocketClient(wsUrl.String(), b.client.AuthToken)
-	if err != nil {
-		log.Fatalf("We failed to connect to the websocket '%s': %v\n", wsUrl.String(), err.Error())
-	} else {
-		log.Printf("Listening to websocket '%s'\n", wsUrl.String())
-	}
-
-	// start listening to websocket
-	wsClient.Listen()
+	// recieve interruption or termination to stop the bot
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		for {
-			select {
-			case event := <-wsClient.EventChannel:
-				b.handleWebsocketEvent(event)
-			}
-		}
+		infof("Recieved signal '%v'\n", <-sig)
+		cancel()
 	}()
 
-	// recieve interruption to stop the bot
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
-	close := make(chan bool, 1)
-
+	// recieve hangup to reload the configuration
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		for _ = range sig {
-			if wsClient != nil {
-				wsClient.Close()
+		for range hup {
+			infof("Recieved signal '%v'\n", syscall.SIGHUP)
+			if err := b.Reload(); err != nil {
+				errorf("We failed to reload the configuration: %v\n", err.Error())
 			}
-
-			close <- true
 		}
 	}()
 
-	<-close
+	b.RunContext(ctx)
 }
 
 func (b *BotKit) AddPlugin(plugin Plugin) {
-	b.plugins = append(b.plugins, plugin)
+	b.AddHandler(&pluginHandler{plugin})
+}
+
+func (b *BotKit) AddHandler(handler Handler) {
+	b.pluginsMu.Lock()
+	defer b.pluginsMu.Unlock()
+	b.plugins = append(b.plugins, handler)
 }
 
 func (b *BotKit) Usage() string {
 	usages := []string{}
-	for _, plugin := range b.plugins {
+	for _, plugin := range b.Plugins() {
 		usages = append(usages, plugin.Usage())
 	}
 	return strings.Join(usages, "\n")
 }
 
+// Help collects the help entries of all plugins.
+// Plugins without a Help method are described by their usage lines.
+func (b *BotKit) Help() []HelpEntry {
+	entries := []HelpEntry{}
+	for _, plugin := range b.Plugins() {
+		if helper, ok := plugin.(Helper); ok {
+			entries = append(entries, helper.Help()...)
+			continue
+		}
+
+		for _, usage := range strings.Split(plugin.Usage(), "\n") {
+			entries = append(entries, HelpEntry{Usage: usage})
+		}
+	}
+	return entries
+}
+
 func (b *BotKit) handleWebsocketEvent(event *model.WebSocketEvent) {
-	// ignore the event if it is not a posted event
-	if event.Event != model.WEBSOCKET_EVENT_POSTED {
+	b.emit(event)
+
+	switch event.Event {
+	case model.WEBSOCKET_EVENT_POSTED:
+	case model.WEBSOCKET_EVENT_USER_ADDED, model.WEBSOCKET_EVENT_USER_REMOVED,
+		model.WEBSOCKET_EVENT_CHANNEL_CREATED, model.WEBSOCKET_EVENT_CHANNEL_DELETED, WEBSOCKET_EVENT_CHANNEL_UPDATED,
+		model.WEBSOCKET_EVENT_DIRECT_ADDED, model.WEBSOCKET_EVENT_GROUP_ADDED:
+		b.handleMembershipEvent(event)
+		return
+	case model.WEBSOCKET_EVENT_USER_UPDATED:
+		b.handleUserUpdatedEvent(event)
+		return
+	default:
+		debugf("Ignored the event '%s'\n", event.Event)
 		return
 	}
 
-	// ignore the post from the bot itself
 	post := model.PostFromJson(strings.NewReader(event.Data["post"].(string)))
-	if post == nil || post.UserId == b.User.Id {
+	if post == nil {
 		return
 	}
 
-	// ignore the post in the channel where bot has not joined
-	isChannelMember := false
-	for _, channel := range b.Channels {
-		if channel.Id == post.ChannelId {
-			isChannelMember = true
-			break
-		}
+	// direct and group messages are not listed in the team channels, join them on the first message
+	channelType, _ := event.Data["channel_type"].(string)
+	if !b.IsMember(post.ChannelId) && (channelType == model.CHANNEL_DIRECT || channelType == model.CHANNEL_GROUP) {
+		b.fetchChannel(post.ChannelId)
 	}
 
-	if isChannelMember {
-		b.handlePost(post)
+	// ignore the post in the channel where bot has not joined
+	if b.IsMember(post.ChannelId) {
+		b.handleNewPost(post)
 	}
 }
 
 func (b *BotKit) handlePost(post *model.Post) {
-	var text, username, channel string
-	var botName, botLinkedName string
+	var channel *model.Channel
+	if result, err := b.getChannel(post.ChannelId); err != nil {
+		errorf("We cannnot get channel by id: %s\n", post.ChannelId)
+		return
+	} else {
+		channel = result
+	}
 
-	botName = b.User.Username
-	botLinkedName = fmt.Sprintf("@%s", b.User.Username)
+	// listeners hear every message, commands are only the messages addressed to the bot
+	text, addressed := b.commandText(post.Message, channel)
 
-	switch {
-	case strings.HasPrefix(post.Message, botName):
-		text = strings.TrimSpace(post.Message[len(botName):])
-	case strings.HasPrefix(post.Message, botLinkedName):
-		text = strings.TrimSpace(post.Message[len(botLinkedName):])
-	default:
-		return
+	var listeners []Listener
+	if b.Config().Hear.Hears(channel.Name) {
+		listeners = b.Listeners()
 	}
 
-	if result, err := b.client.GetChannel(post.ChannelId, ""); err != nil {
-		log.Printf("We cannnot get channel by id: %s\n", post.ChannelId)
+	if !addressed && len(listeners) == 0 {
 		return
-	} else {
-		channelData := result.Data.(*model.ChannelData)
-		channel = channelData.Channel.Name
 	}
 
-	if result, err := b.client.GetUser(post.UserId, ""); err != nil {
-		log.Printf("We cannnot get user by id: %s\n", post.UserId)
+	var user *model.User
+	if result, err := b.getUser(post.UserId); err != nil {
+		errorf("We cannnot get user by id: %s\n", post.UserId)
 		return
 	} else {
-		user := result.Data.(*model.User)
-		username = user.Username
+		user = result
 	}
 
-	log.Printf("Recieved a command '%s' from user '%s' in the channel '%s'", text, username, channel)
+	if len(listeners) > 0 {
+		msg := newMessage(b, strings.TrimSpace(post.Message), post, channel, user)
 
-	wg := &sync.WaitGroup{}
-	for _, plugin := range b.plugins {
-		wg.Add(1)
-		go func(p Plugin) {
-			defer wg.Done()
-			p.HandleMessage(text, channel, username)
-		}(plugin)
+		b.inflight.Add(1)
+		go func() {
+			defer b.inflight.Done()
+			b.hear(msg, listeners)
+		}()
+	}
+
+	if addressed {
+		msg := newMessage(b, text, post, channel, user)
+		infof("Recieved a command '%s' from user '%s' in the channel '%s'", msg.Text, msg.Username, msg.Channel)
+
+		b.inflight.Add(1)
+		go func() {
+			defer b.inflight.Done()
+			b.dispatch(msg)
+		}()
 	}
-	wg.Wait()
 }
 
-func (b *BotKit) getChannels() (*model.Result, error) {
-	if r, err := b.client.DoApiGet(fmt.Sprintf("/teams/%v/channels/", b.Team.Id), "", ""); err != nil {
-		return nil, err
-	} else {
-		defer r.Body.Close()
-		return &model.Result{
-			r.Header.Get(model.HEADER_REQUEST_ID),
-			r.Header.Get(model.HEADER_ETAG_SERVER),
-			model.ChannelSliceFromJson(r.Body)}, nil
+// rerun dispatches the command in the post again, as sent by the user.
+func (b *BotKit) rerun(post *model.Post, userId string) error {
+	channel, err := b.getChannel(post.ChannelId)
+	if err != nil {
+		return fmt.Errorf("Channel '%s' is not found", post.ChannelId)
+	}
+
+	text, addressed := b.commandText(post.Message, channel)
+	if !addressed {
+		return fmt.Errorf("Post '%s' is not a command", post.Id)
+	}
+
+	user, err := b.getUser(userId)
+	if err != nil {
+		return fmt.Errorf("User '%s' is not found", userId)
 	}
+
+	msg := newMessage(b, text, post, channel, user)
+	infof("Rerun a command '%s' for user '%s' in the channel '%s'", msg.Text, msg.Username, msg.Channel)
+
+	b.inflight.Add(1)
+	go func() {
+		defer b.inflight.Done()
+		b.dispatch(msg)
+	}()
+	return nil
 }
@@ -0,0 +1,185 @@
+package mmbot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/platform/model"
+)
+
+// MessageBuilder composes a message of text blocks, markdown tables, code blocks, attachments and props.
+//
+//	out := mmbot.NewMessageBuilder().
+//		Text("Deployed.").
+//		Table([]string{"host", "version"}, [][]string{{"web1", "1.2.0"}}).
+//		Attach(mmbot.NewAttachment().Color("#36a64f").Title("Release notes", url).Field("Author", "alice", true)).
+//		Build()
+type MessageBuilder struct {
+	blocks []string
+	out    *OutgoingMessage
+}
+
+func NewMessageBuilder() *MessageBuilder {
+	return &MessageBuilder{out: &OutgoingMessage{}}
+}
+
+// Text adds a paragraph.
+func (mb *MessageBuilder) Text(text string) *MessageBuilder {
+	mb.blocks = append(mb.blocks, text)
+	return mb
+}
+
+func (mb *MessageBuilder) Textf(format string, a ...interface{}) *MessageBuilder {
+	return mb.Text(fmt.Sprintf(format, a...))
+}
+
+// Table adds a markdown table. Rows shorter than the header are padded.
+func (mb *MessageBuilder) Table(header []string, rows [][]string) *MessageBuilder {
+	lines := []string{tableRow(header, len(header))}
+
+	separators := make([]string, len(header))
+	for i := range separators {
+		separators[i] = "---"
+	}
+	lines = append(lines, tableRow(separators, len(header)))
+
+	for _, row := range rows {
+		lines = append(lines, tableRow(row, len(header)))
+	}
+	return mb.Text(strings.Join(lines, "\n"))
+}
+
+// Code adds a code block highlighted as the language, or plain if it is empty.
+func (mb *MessageBuilder) Code(language, code string) *MessageBuilder {
+	return mb.Text(fmt.Sprintf("```%s\n%s\n```", language, strings.TrimRight(code, "\n")))
+}
+
+func (mb *MessageBuilder) Attach(attachment *AttachmentBuilder) *MessageBuilder {
+	mb.out.Attachments = append(mb.out.Attachments, attachment.attachment)
+	return mb
+}
+
+// Prop sets a property of the post, such as "from_webhook" or a custom one read by integrations.
+func (mb *MessageBuilder) Prop(key string, value interface{}) *MessageBuilder {
+	if mb.out.Props == nil {
+		mb.out.Props = map[string]interface{}{}
+	}
+	mb.out.Props[key] = value
+	return mb
+}
+
+// As overrides the bot profile when sending with the incoming webhook.
+func (mb *MessageBuilder) As(username, iconUrl string) *MessageBuilder {
+	mb.out.Username = username
+	mb.out.IconUrl = iconUrl
+	return mb
+}
+
+// Build returns the message. Set its channel, or pass it to ReplyMessage.
+// The message is a copy, which does not change when the builder is used again.
+func (mb *MessageBuilder) Build() *OutgoingMessage {
+	out := *mb.out
+	out.Text = strings.Join(mb.blocks, "\n\n")
+
+	if mb.out.Props != nil {
+		out.Props = map[string]interface{}{}
+		for key, value := range mb.out.Props {
+			out.Props[key] = value
+		}
+	}
+
+	out.Attachments = nil
+	for _, attachment := range mb.out.Attachments {
+		out.Attachments = append(out.Attachments, copyAttachment(attachment))
+	}
+	return &out
+}
+
+func copyAttachment(attachment *model.SlackAttachment) *model.SlackAttachment {
+	copied := *attachment
+	copied.Fields = nil
+	for _, field := range attachment.Fields {
+		f := *field
+		copied.Fields = append(copied.Fields, &f)
+	}
+	return &copied
+}
+
+func tableRow(cells []string, columns int) string {
+	escaped := make([]string, columns)
+	for i := range escaped {
+		if i < len(cells) {
+			// a pipe or a newline breaks the row
+			cell := strings.Replace(cells[i], "|", "\\|", -1)
+			escaped[i] = strings.Replace(cell, "\n", " ", -1)
+		}
+	}
+	return "| " + strings.Join(escaped, " | ") + " |"
+}
+
+// AttachmentBuilder composes a Slack-style attachment.
+type AttachmentBuilder struct {
+	attachment *model.SlackAttachment
+}
+
+func NewAttachment() *AttachmentBuilder {
+	return &AttachmentBuilder{attachment: &model.SlackAttachment{}}
+}
+
+// Fallback is the plain text shown where the attachment cannot be displayed.
+func (ab *AttachmentBuilder) Fallback(text string) *AttachmentBuilder {
+	ab.attachment.Fallback = text
+	return ab
+}
+
+// Color is a hex color such as "#ff0000", or "good", "warning" and "danger".
+func (ab *AttachmentBuilder) Color(color string) *AttachmentBuilder {
+	ab.attachment.Color = color
+	return ab
+}
+
+func (ab *AttachmentBuilder) Pretext(text string) *AttachmentBuilder {
+	ab.attachment.Pretext = text
+	return ab
+}
+
+func (ab *AttachmentBuilder) Author(name, link, iconUrl string) *AttachmentBuilder {
+	ab.attachment.AuthorName = name
+	ab.attachment.AuthorLink = link
+	ab.attachment.AuthorIcon = iconUrl
+	return ab
+}
+
+// Title links to the url unless it is empty.
+func (ab *AttachmentBuilder) Title(title, url string) *AttachmentBuilder {
+	ab.attachment.Title = title
+	ab.attachment.TitleLink = url
+	return ab
+}
+
+func (ab *AttachmentBuilder) Text(text string) *AttachmentBuilder {
+	ab.attachment.Text = text
+	return ab
+}
+
+// Field adds a field. Short fields are shown side by side.
+func (ab *AttachmentBuilder) Field(title string, value interface{}, short bool) *AttachmentBuilder {
+	ab.attachment.Fields = append(ab.attachment.Fields, &model.SlackAttachmentField{Title: title, Value: value, Short: short})
+	return ab
+}
+
+func (ab *AttachmentBuilder) Image(url string) *AttachmentBuilder {
+	ab.attachment.ImageURL = url
+	return ab
+}
+
+func (ab *AttachmentBuilder) Thumb(url string) *AttachmentBuilder {
+	ab.attachment.ThumbURL = url
+	return ab
+}
+
+func (ab *AttachmentBuilder) Footer(text, iconUrl string) *AttachmentBuilder {
+	ab.attachment.Footer = text
+	ab.attachment.FooterIcon = iconUrl
+	return ab
+}
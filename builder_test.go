@@ -0,0 +1,100 @@
+package mmbot
+
+import (
+	"testing"
+
+	"github.com/mattermost/platform/model"
+)
+
+func TestMessageBuilder(t *testing.T) {
+	mb := NewMessageBuilder().
+		Text("Deployed.").
+		Table([]string{"host", "version"}, [][]string{{"web|1", "1.2.0"}, {"web2"}}).
+		Code("", "echo 1\n").
+		Prop("release", "1.2.0")
+
+	out := mb.Build()
+	expected := "Deployed.\n\n" +
+		"| host | version |\n| --- | --- |\n| web\\|1 | 1.2.0 |\n| web2 |  |\n\n" +
+		"```\necho 1\n```"
+	if out.Text != expected {
+		t.Fatalf("unexpected text %q", out.Text)
+	}
+
+	// the built message does not change with the builder
+	mb.Text("Done.").Prop("release", "1.3.0").Attach(NewAttachment().Field("Author", "alice", true))
+	if out.Props["release"] != "1.2.0" || len(out.Attachments) != 0 {
+		t.Fatalf("expected the built message to be kept, got %v and %v", out.Props, out.Attachments)
+	}
+}
+
+// builtTestMessage returns a message with an attachment, sent as "deployer".
+func builtTestMessage() *OutgoingMessage {
+	out := NewMessageBuilder().
+		Text("Deployed.").
+		Attach(NewAttachment().Color("good").Title("Release notes", "https://example.com").Field("Author", "alice", true)).
+		Prop("release", "1.2.0").
+		As("deployer", "https://example.com/icon.png").
+		Build()
+	out.Channel = "town-square"
+	return out
+}
+
+func checkBuiltTestPost(t *testing.T, post *model.Post) {
+	t.Helper()
+
+	if post.Message != "Deployed." {
+		t.Fatalf("unexpected message %q", post.Message)
+	}
+	for key, value := range map[string]interface{}{
+		"release":           "1.2.0",
+		"override_username": "deployer",
+		"override_icon_url": "https://example.com/icon.png",
+	} {
+		if post.Props[key] != value {
+			t.Fatalf("expected %s to be %v, got %v", key, value, post.Props[key])
+		}
+	}
+
+	attachments, ok := post.Props["attachments"].([]*model.SlackAttachment)
+	if !ok || len(attachments) != 1 {
+		t.Fatalf("expected an attachment, got %v", post.Props["attachments"])
+	}
+	if a := attachments[0]; a.Color != "good" || a.TitleLink != "https://example.com" || len(a.Fields) != 1 || a.Fields[0].Value != "alice" {
+		t.Fatalf("unexpected attachment %+v", a)
+	}
+}
+
+func TestSendBuiltMessageWithAPI(t *testing.T) {
+	b, adapter := newTestBot(t)
+
+	if _, err := b.SendAndWait(b.ctx, builtTestMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	post := adapter.Posts()[0]
+	checkBuiltTestPost(t, post)
+	if post.Type != model.POST_SLACK_ATTACHMENT || post.Props["from_webhook"] != "true" {
+		t.Fatalf("expected the post to be shown as from an integration, got %q and %v", post.Type, post.Props["from_webhook"])
+	}
+}
+
+func TestSendBuiltMessageWithWebhook(t *testing.T) {
+	b, adapter := newTestBot(t)
+	setTestConfig(b, func(config *Config) { config.Webhook = "https://chat.example.com/hooks/abc" })
+
+	// the posts of the webhook have no handle to wait for
+	if err := b.Send(builtTestMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SendMessage("hello", "town-square", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the posts", func() bool { return len(adapter.Posts()) == 2 })
+	checkBuiltTestPost(t, adapter.Posts()[0])
+
+	// the webhook posts as the bot unless overridden
+	if username := adapter.Posts()[1].Props["override_username"]; username != b.User.Username {
+		t.Fatalf("expected the username of the bot, got %v", username)
+	}
+}
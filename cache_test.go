@@ -0,0 +1,73 @@
+package mmbot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/platform/model"
+)
+
+func TestCacheExpires(t *testing.T) {
+	c := newCache(20*time.Millisecond, 10)
+	c.Set("a", 1)
+	if value, ok := c.Get("a"); !ok || value != 1 {
+		t.Fatalf("expected 1, got %v", value)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if value, ok := c.Get("a"); ok {
+		t.Fatalf("expected the value to expire, got %v", value)
+	}
+	if c.lru.Len() != 0 || len(c.entries) != 0 {
+		t.Fatal("expected the expired entry to be removed")
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newCache(time.Minute, 2)
+	c.Set("a", 1)
+	c.Set("b", 2)
+
+	// reading a makes b the least recently used
+	c.Get("a")
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	for key, expected := range map[string]int{"a": 1, "c": 3} {
+		if value, ok := c.Get(key); !ok || value != expected {
+			t.Fatalf("expected %s to be %d, got %v", key, expected, value)
+		}
+	}
+
+	// setting a key again does not grow the cache
+	c.Set("a", 4)
+	if value, _ := c.Get("a"); value != 4 || c.lru.Len() != 2 {
+		t.Fatalf("expected a to be replaced, got %v in %d entries", value, c.lru.Len())
+	}
+}
+
+func TestUserCacheInvalidated(t *testing.T) {
+	b, adapter := newTestBot(t)
+	userId := newTestPost(t, b, "").UserId
+
+	if user, err := b.getUser(userId); err != nil || user.Username != "alice" {
+		t.Fatalf("expected alice, got %v", user)
+	}
+
+	// the cached user is read until the server tells it was updated
+	adapter.InMemoryAdapter.mu.Lock()
+	adapter.users[userId] = &model.User{Id: userId, Username: "alice2"}
+	adapter.InMemoryAdapter.mu.Unlock()
+	if user, _ := b.getUser(userId); user.Username != "alice" {
+		t.Fatalf("expected the cached user, got %v", user.Username)
+	}
+
+	event := model.NewWebSocketEvent(model.WEBSOCKET_EVENT_USER_UPDATED, "", "", "", nil)
+	event.Add("user", map[string]interface{}{"id": userId})
+	b.handleWebsocketEvent(event)
+	if user, _ := b.getUser(userId); user.Username != "alice2" {
+		t.Fatalf("expected the updated user, got %v", user.Username)
+	}
+}
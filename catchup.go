@@ -0,0 +1,108 @@
+package mmbot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/platform/model"
+)
+
+const (
+	CATCHUP_MAX_AGE = 10 * time.Minute
+)
+
+// catchUp handles the posts created while the bot was not listening to the server.
+// Posts older than the maximum age are skipped, so that very old commands are not replayed.
+func (b *BotKit) catchUp() {
+	if !b.Config().CatchUp.Enabled {
+		return
+	}
+
+	oldest := model.GetMillis() - int64(b.Config().CatchUp.MaxAge/time.Millisecond)
+	for _, channel := range b.Channels() {
+		since := b.lastPostAt(channel.Id)
+		if since == 0 {
+			// nothing is known about the channel yet
+			continue
+		}
+
+		if since < oldest {
+			since = oldest
+		}
+
+		// include the millisecond of the last post, whose other posts may have been missed
+		posts, err := b.adapter.GetPostsSince(channel.Id, since-1)
+		if err != nil {
+			errorf("We failed to get the posts since %d in the channel '%s': %v\n", since, channel.Name, err.Error())
+			continue
+		}
+
+		if len(posts) > 0 {
+			infof("Catch up %d posts in the channel '%s'\n", len(posts), channel.Name)
+		}
+
+		for _, post := range posts {
+			// the posts only edited, reacted to or deleted since then are returned too
+			if post.DeleteAt != 0 || post.CreateAt < oldest {
+				continue
+			}
+			b.handleNewPost(post)
+		}
+	}
+}
+
+// handleNewPost handles the post unless it has already been handled.
+// The ids of the posts of the last millisecond are kept, as several posts may be created in the same millisecond.
+func (b *BotKit) handleNewPost(post *model.Post) {
+	last := b.lastPostAt(post.ChannelId)
+	if post.CreateAt < last {
+		return
+	}
+
+	ids := []string{}
+	if post.CreateAt == last {
+		ids = b.lastPostIds(post.ChannelId)
+		for _, id := range ids {
+			if id == post.Id {
+				return
+			}
+		}
+	}
+	b.setLastPost(post.ChannelId, post.CreateAt, append(ids, post.Id))
+
+	// ignore the post from the bot itself
+	if post.UserId == b.User.Id {
+		return
+	}
+
+	b.handlePost(post)
+}
+
+func (b *BotKit) lastPostAt(channelId string) int64 {
+	val, err := b.Memory.Get(b, fmt.Sprintf("last_post:%s", channelId))
+	if err != nil {
+		return 0
+	}
+
+	at, _ := strconv.ParseInt(val, 10, 64)
+	return at
+}
+
+func (b *BotKit) lastPostIds(channelId string) []string {
+	val, err := b.Memory.Get(b, fmt.Sprintf("last_post_ids:%s", channelId))
+	if err != nil || val == "" {
+		return []string{}
+	}
+	return strings.Split(val, ",")
+}
+
+func (b *BotKit) setLastPost(channelId string, at int64, ids []string) {
+	if err := b.Memory.Put(b, fmt.Sprintf("last_post:%s", channelId), strconv.FormatInt(at, 10)); err != nil {
+		errorf("We failed to save the last post time of the channel '%s': %v\n", channelId, err.Error())
+	}
+	if err := b.Memory.Put(b, fmt.Sprintf("last_post_ids:%s", channelId), strings.Join(ids, ",")); err != nil {
+		errorf("We failed to save the last posts of the channel '%s': %v\n", channelId, err.Error())
+	}
+}
@@ -0,0 +1,100 @@
+package mmbot
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mattermost/platform/model"
+)
+
+func TestHandleNewPostSameMillisecond(t *testing.T) {
+	b, _ := newTestBot(t)
+	handler := &recordingHandler{}
+	b.AddHandler(handler)
+
+	first := newTestPost(t, b, "@bot first")
+	second := newTestPost(t, b, "@bot second")
+	second.CreateAt = first.CreateAt
+	older := newTestPost(t, b, "@bot older")
+	older.CreateAt = first.CreateAt - 1
+
+	// the second post of the millisecond is handled, the repeated and older ones are not
+	for _, post := range []*model.Post{first, second, first, second, older} {
+		b.handleNewPost(post)
+	}
+
+	waitFor(t, "the posts", func() bool { return len(handler.handled()) == 2 })
+	time.Sleep(50 * time.Millisecond)
+	if texts := handler.handled(); len(texts) != 2 {
+		t.Fatalf("expected 2 posts handled, got %v", texts)
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	b, adapter := newTestBot(t)
+	setTestConfig(b, func(config *Config) { config.CatchUp.Enabled = true })
+	handler := &recordingHandler{}
+	b.AddHandler(handler)
+
+	// the bot handled a post before it was disconnected
+	first, err := adapter.Receive("alice", "town-square", "@bot one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.handleNewPost(first)
+	waitFor(t, "the first post", func() bool { return len(handler.handled()) == 1 })
+
+	// the posts sent while the bot was disconnected
+	for _, text := range []string{"@bot two", "@bot gone", "@bot three"} {
+		post, err := adapter.Receive("alice", "town-square", text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text == "@bot gone" {
+			adapter.DeletePost(post.ChannelId, post.Id)
+		}
+	}
+
+	b.catchUp()
+	b.catchUp()
+	waitFor(t, "the missed posts", func() bool { return len(handler.handled()) == 3 })
+	time.Sleep(50 * time.Millisecond)
+
+	texts := handler.handled()
+	sort.Strings(texts)
+	if fmt.Sprint(texts) != fmt.Sprint([]string{"one", "three", "two"}) {
+		t.Fatalf("expected the missed posts to be handled once, got %v", texts)
+	}
+}
+
+func TestCatchUpMaxAge(t *testing.T) {
+	b, adapter := newTestBot(t)
+	setTestConfig(b, func(config *Config) {
+		config.CatchUp.Enabled = true
+		config.CatchUp.MaxAge = time.Minute
+	})
+	handler := &recordingHandler{}
+	b.AddHandler(handler)
+
+	// the bot was disconnected for an hour
+	channel, _ := b.getChannelByName("town-square")
+	b.setLastPost(channel.Id, model.GetMillis()-int64(time.Hour/time.Millisecond), []string{})
+
+	old := newTestPost(t, b, "@bot old")
+	old.CreateAt = model.GetMillis() - int64(30*time.Minute/time.Millisecond)
+	adapter.InMemoryAdapter.mu.Lock()
+	adapter.history = append(adapter.history, old)
+	adapter.InMemoryAdapter.mu.Unlock()
+	if _, err := adapter.Receive("alice", "town-square", "@bot recent"); err != nil {
+		t.Fatal(err)
+	}
+
+	b.catchUp()
+	waitFor(t, "the recent post", func() bool { return len(handler.handled()) == 1 })
+	time.Sleep(50 * time.Millisecond)
+	if texts := handler.handled(); len(texts) != 1 || texts[0] != "recent" {
+		t.Fatalf("expected only the recent post, got %v", texts)
+	}
+}
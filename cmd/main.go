@@ -11,10 +11,11 @@ import (
 
 func main() {
 	bot := mmbot.NewBotKit()
-	bot.AddPlugin(batch.NewPlugin(bot))
-	bot.AddPlugin(cron.NewPlugin(bot))
-	bot.AddPlugin(echo.NewPlugin(bot))
-	bot.AddPlugin(help.NewPlugin(bot))
-	bot.AddPlugin(ping.NewPlugin(bot))
+	bot.Use(mmbot.Recover(), mmbot.Logger())
+	bot.AddHandler(batch.NewPlugin(bot))
+	bot.AddHandler(cron.NewPlugin(bot))
+	bot.AddHandler(echo.NewPlugin(bot))
+	bot.AddHandler(help.NewPlugin(bot))
+	bot.AddHandler(ping.NewPlugin(bot))
 	bot.Run()
 }
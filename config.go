@@ -0,0 +1,306 @@
+package mmbot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	CONFIG_PATH = "mmbot.yml"
+)
+
+// Config is the configuration of the bot, loaded from a YAML file and overridden by environment variables.
+type Config struct {
+	Endpoint    string        `yaml:"endpoint"`
+	Webhook     string        `yaml:"webhook"`
+	Account     string        `yaml:"account"`
+	Password    string        `yaml:"password"`
+	Token       string        `yaml:"token"`
+	Teamname    string        `yaml:"teamname"`
+	LevelDBPath string        `yaml:"leveldb_path"`
+	LogLevel    string        `yaml:"log_level"`
+	CatchUp     CatchUpConfig `yaml:"catchup"`
+	Triggers    TriggerConfig `yaml:"triggers"`
+	Hear        HearConfig    `yaml:"hear"`
+	Queue       QueueConfig   `yaml:"queue"`
+	ACL         ACLConfig     `yaml:"acl"`
+
+	// the plugins handling messages, or all of them if empty
+	EnabledPlugins []string               `yaml:"enabled_plugins"`
+	Plugins        map[string]interface{} `yaml:"plugins"`
+
+	path string
+}
+
+type CatchUpConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	MaxAge  time.Duration `yaml:"max_age"`
+}
+
+// ACLConfig decides who can use the bot, and each plugin in the plugins section.
+type ACLConfig struct {
+	ACLRule `yaml:",inline"`
+	Plugins map[string]ACLRule `yaml:"plugins"`
+}
+
+// ACLRule allows the listed users, or everyone if the list is empty, except the denied users.
+type ACLRule struct {
+	AllowUsers []string `yaml:"allow_users"`
+	DenyUsers  []string `yaml:"deny_users"`
+}
+
+func (r ACLRule) Allows(username string) bool {
+	for _, denied := range r.DenyUsers {
+		if denied == username {
+			return false
+		}
+	}
+
+	if len(r.AllowUsers) == 0 {
+		return true
+	}
+	for _, allowed := range r.AllowUsers {
+		if allowed == username {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows returns true if the user can use the plugin.
+func (c ACLConfig) Allows(plugin, username string) bool {
+	return c.ACLRule.Allows(username) && c.Plugins[plugin].Allows(username)
+}
+
+// PluginConfig holds the settings shared by plugins. Embed it inline in the configuration struct of a plugin.
+type PluginConfig struct {
+	Username string `yaml:"username"`
+	IconUrl  string `yaml:"icon_url"`
+}
+
+// LoadConfig reads the file named by MMBOT_CONFIG, or mmbot.yml if it exists,
+// and overrides its values with the environment variables.
+func LoadConfig() (*Config, error) {
+	config := &Config{
+		LevelDBPath: LEVELDB_PATH,
+		CatchUp:     CatchUpConfig{MaxAge: CATCHUP_MAX_AGE},
+		Hear:        HearConfig{Interval: HEAR_INTERVAL},
+		Queue: QueueConfig{
+			Interval:        QUEUE_INTERVAL,
+			ChannelInterval: QUEUE_CHANNEL_INTERVAL,
+			MaxRetries:      QUEUE_MAX_RETRIES,
+			Size:            QUEUE_SIZE,
+		},
+		Plugins: map[string]interface{}{},
+		path:    os.Getenv("MMBOT_CONFIG"),
+	}
+
+	if config.path == "" {
+		// the default file is optional
+		if _, err := os.Stat(CONFIG_PATH); err == nil {
+			config.path = CONFIG_PATH
+		}
+	}
+
+	if config.path != "" {
+		data, err := ioutil.ReadFile(config.path)
+		if err != nil {
+			return nil, fmt.Errorf("We failed to read the configuration file: %v", err.Error())
+		}
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("We failed to parse the configuration file '%s': %v", config.path, err.Error())
+		}
+	}
+
+	if err := config.overrideWithEnv(); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (c *Config) overrideWithEnv() error {
+	strs := map[string]*string{
+		"MMBOT_ENDPOINT":  &c.Endpoint,
+		"MMBOT_WEBHOOK":   &c.Webhook,
+		"MMBOT_ACCOUNT":   &c.Account,
+		"MMBOT_PASSWORD":  &c.Password,
+		"MMBOT_TOKEN":     &c.Token,
+		"MMBOT_TEAMNAME":  &c.Teamname,
+		"LEVELDB_PATH":    &c.LevelDBPath,
+		"MMBOT_LOG_LEVEL": &c.LogLevel,
+	}
+	for name, field := range strs {
+		if val := os.Getenv(name); val != "" {
+			*field = val
+		}
+	}
+
+	if val := os.Getenv("MMBOT_CATCHUP"); val != "" {
+		c.CatchUp.Enabled = val == "true"
+	}
+	if val := os.Getenv("MMBOT_CATCHUP_MAX_AGE"); val != "" {
+		if d, err := time.ParseDuration(val); err != nil {
+			return fmt.Errorf("Invalid MMBOT_CATCHUP_MAX_AGE '%s': %v", val, err.Error())
+		} else {
+			c.CatchUp.MaxAge = d
+		}
+	}
+	return nil
+}
+
+// Validate reports all problems of the configuration at once.
+func (c *Config) Validate() error {
+	problems := []string{}
+
+	if c.Endpoint != "" {
+		if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			problems = append(problems, fmt.Sprintf("endpoint '%s' is not a http or https URL", c.Endpoint))
+		}
+	}
+	if c.Token == "" && c.Account != "" && c.Password == "" {
+		problems = append(problems, "password is required to log in with the account")
+	}
+	if c.LevelDBPath == "" {
+		problems = append(problems, "leveldb_path is empty")
+	}
+	if _, err := ParseLogLevel(c.LogLevel); err != nil {
+		problems = append(problems, fmt.Sprintf("log_level: %v", err.Error()))
+	}
+	if c.CatchUp.MaxAge <= 0 {
+		problems = append(problems, fmt.Sprintf("catchup.max_age must be positive, not %v", c.CatchUp.MaxAge))
+	}
+	if c.Hear.Interval < 0 {
+		problems = append(problems, fmt.Sprintf("hear.interval must not be negative, not %v", c.Hear.Interval))
+	}
+	if c.Queue.Interval < 0 || c.Queue.ChannelInterval < 0 {
+		problems = append(problems, "queue.interval and queue.channel_interval must not be negative")
+	}
+	if c.Queue.MaxRetries < 0 {
+		problems = append(problems, fmt.Sprintf("queue.max_retries must not be negative, not %d", c.Queue.MaxRetries))
+	}
+	if c.Queue.Size <= 0 {
+		problems = append(problems, fmt.Sprintf("queue.size must be positive, not %d", c.Queue.Size))
+	}
+	for name, section := range c.Plugins {
+		if _, ok := section.(map[interface{}]interface{}); !ok && section != nil {
+			problems = append(problems, fmt.Sprintf("plugins.%s must be a mapping", name))
+		}
+	}
+
+	return c.problems(problems)
+}
+
+// validateServer checks the settings needed to connect to a Mattermost server.
+func (c *Config) validateServer() error {
+	problems := []string{}
+
+	if c.Endpoint == "" {
+		problems = append(problems, "endpoint is required")
+	}
+	if c.Teamname == "" {
+		problems = append(problems, "teamname is required")
+	}
+	if c.Token == "" && c.Account == "" {
+		problems = append(problems, "token or account is required")
+	}
+
+	return c.problems(problems)
+}
+
+func (c *Config) problems(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+
+	source := "the environment variables"
+	if c.path != "" {
+		source = fmt.Sprintf("'%s' and the environment variables", c.path)
+	}
+	return fmt.Errorf("Invalid configuration in %s:\n  %s", source, strings.Join(problems, "\n  "))
+}
+
+// PluginEnabled returns true if the plugin is in the enable list, or the list is empty.
+func (c *Config) PluginEnabled(name string) bool {
+	if len(c.EnabledPlugins) == 0 {
+		return true
+	}
+	for _, enabled := range c.EnabledPlugins {
+		if enabled == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newAdapter connects to Mattermost, preferring the access token to the password.
+func (c *Config) newAdapter() Adapter {
+	if c.Token != "" {
+		return NewMattermostAdapterWithToken(c.Endpoint, c.Token, c.Teamname)
+	}
+	return NewMattermostAdapter(c.Endpoint, c.Account, c.Password, c.Teamname)
+}
+
+// Plugin decodes the section of the plugin into v. Fields missing in the section keep their values.
+func (c *Config) Plugin(name string, v interface{}) error {
+	section, ok := c.Plugins[name]
+	if !ok || section == nil {
+		return nil
+	}
+
+	data, err := yaml.Marshal(section)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("Invalid configuration of the plugin '%s': %v", name, err.Error())
+	}
+	return nil
+}
+
+// Config returns the configuration of the bot. It is replaced as a whole when the bot reloads it.
+func (b *BotKit) Config() *Config {
+	b.configMu.RLock()
+	defer b.configMu.RUnlock()
+	return b.config
+}
+
+// PluginConfig decodes the section of the plugin into v.
+// The section is named after the package of the plugin, such as "cron" for *cron.Plugin.
+func (b *BotKit) PluginConfig(plugin interface{}, v interface{}) error {
+	return b.Config().Plugin(PluginName(plugin), v)
+}
+
+// PluginName returns the name of the plugin used in the configuration.
+func PluginName(plugin interface{}) string {
+	if h, ok := plugin.(Handler); ok {
+		plugin = pluginOf(h)
+	}
+
+	// a function belongs to the package it is defined in, such as "example.com/plugins/cron.(*Plugin).onEdited-fm"
+	if v := reflect.ValueOf(plugin); v.Kind() == reflect.Func {
+		name := path.Base(runtime.FuncForPC(v.Pointer()).Name())
+		return strings.SplitN(name, ".", 2)[0]
+	}
+
+	t := reflect.TypeOf(plugin)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.PkgPath() == "" {
+		return strings.ToLower(t.Name())
+	}
+	return path.Base(t.PkgPath())
+}
@@ -0,0 +1,154 @@
+package mmbot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestConfig writes the YAML to a temporary file and points MMBOT_CONFIG to it.
+func writeTestConfig(t *testing.T, yml string) string {
+	dir, err := ioutil.TempDir("", "mmbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "mmbot.yml")
+	if err := ioutil.WriteFile(path, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MMBOT_CONFIG", path)
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	writeTestConfig(t, `
+endpoint: https://chat.example.com
+teamname: team
+token: secret
+catchup:
+  enabled: false
+  max_age: 1h
+plugins:
+  cron:
+    username: cron-bot
+    schedule: "@daily"
+`)
+	t.Setenv("MMBOT_TEAMNAME", "other")
+	t.Setenv("MMBOT_CATCHUP", "true")
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the environment variables override the file, and the defaults fill the rest
+	if config.Endpoint != "https://chat.example.com" || config.Teamname != "other" {
+		t.Fatalf("unexpected server settings %s, %s", config.Endpoint, config.Teamname)
+	}
+	if !config.CatchUp.Enabled || config.CatchUp.MaxAge != time.Hour {
+		t.Fatalf("unexpected catchup %+v", config.CatchUp)
+	}
+	if config.LevelDBPath != LEVELDB_PATH || config.Queue.Size != QUEUE_SIZE {
+		t.Fatalf("expected the defaults, got %s and %d", config.LevelDBPath, config.Queue.Size)
+	}
+}
+
+func TestLoadConfigInvalidEnv(t *testing.T) {
+	writeTestConfig(t, "")
+	t.Setenv("MMBOT_CATCHUP_MAX_AGE", "soon")
+
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "Invalid MMBOT_CATCHUP_MAX_AGE 'soon'") {
+		t.Fatalf("expected the invalid duration to be reported, got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	path := writeTestConfig(t, `
+endpoint: chat.example.com
+account: bot
+log_level: loud
+catchup:
+  max_age: 0s
+queue:
+  size: 0
+plugins:
+  cron: daily
+`)
+
+	_, err := LoadConfig()
+	if err == nil {
+		t.Fatal("expected the configuration to be invalid")
+	}
+
+	// all problems are reported at once, with the file they come from
+	for _, problem := range []string{
+		"'" + path + "' and the environment variables",
+		"endpoint 'chat.example.com' is not a http or https URL",
+		"password is required",
+		"log_level:",
+		"catchup.max_age must be positive",
+		"queue.size must be positive",
+		"plugins.cron must be a mapping",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q in %v", problem, err)
+		}
+	}
+}
+
+func TestConfigPlugin(t *testing.T) {
+	writeTestConfig(t, `
+plugins:
+  cron:
+    username: cron-bot
+    schedule: "@daily"
+  broken:
+    retries: many
+`)
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cron struct {
+		PluginConfig `yaml:",inline"`
+		Schedule     string `yaml:"schedule"`
+		Timezone     string `yaml:"timezone"`
+	}
+	cron.Timezone = "UTC"
+	if err := config.Plugin("cron", &cron); err != nil {
+		t.Fatal(err)
+	}
+	if cron.Username != "cron-bot" || cron.Schedule != "@daily" || cron.Timezone != "UTC" {
+		t.Fatalf("unexpected plugin config %+v", cron)
+	}
+
+	// a missing section keeps the values
+	if err := config.Plugin("missing", &cron); err != nil || cron.Schedule != "@daily" {
+		t.Fatalf("expected the values to be kept, got %+v and %v", cron, err)
+	}
+
+	var broken struct {
+		Retries int `yaml:"retries"`
+	}
+	if err := config.Plugin("broken", &broken); err == nil || !strings.Contains(err.Error(), "plugin 'broken'") {
+		t.Fatalf("expected the invalid section to be reported, got %v", err)
+	}
+}
+
+func TestPluginEnabled(t *testing.T) {
+	config := &Config{}
+	if !config.PluginEnabled("cron") {
+		t.Fatal("expected all plugins to be enabled by default")
+	}
+
+	config.EnabledPlugins = []string{"echo"}
+	if !config.PluginEnabled("echo") || config.PluginEnabled("cron") {
+		t.Fatal("expected only the listed plugins to be enabled")
+	}
+}
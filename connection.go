@@ -0,0 +1,158 @@
+package mmbot
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/mattermost/platform/model"
+)
+
+const (
+	PING_INTERVAL      = 30 * time.Second
+	RECONNECT_MIN_WAIT = time.Second
+	RECONNECT_MAX_WAIT = 2 * time.Minute
+)
+
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnecting
+	StateConnected
+	StateReconnecting
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	default:
+		return "disconnected"
+	}
+}
+
+// State returns the current state of the connection to the chat server.
+func (b *BotKit) State() ConnectionState {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	return b.state
+}
+
+// OnStateChange registers a function called whenever the connection state changes.
+func (b *BotKit) OnStateChange(fn func(ConnectionState)) {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	b.stateListeners = append(b.stateListeners, fn)
+}
+
+func (b *BotKit) setState(state ConnectionState) {
+	b.stateMu.Lock()
+	if b.state == state {
+		b.stateMu.Unlock()
+		return
+	}
+	b.state = state
+	listeners := b.stateListeners
+	b.stateMu.Unlock()
+
+	infof("Connection state changed to '%s'\n", state)
+	for _, fn := range listeners {
+		fn(state)
+	}
+}
+
+// listen keeps receiving events from the chat server, reconnecting whenever the connection drops.
+func (b *BotKit) listen(ctx context.Context) {
+	defer b.setState(StateDisconnected)
+
+	backoff := &backoff{min: RECONNECT_MIN_WAIT, max: RECONNECT_MAX_WAIT}
+	for {
+		b.setState(StateConnecting)
+		if events, err := b.adapter.Listen(); err != nil {
+			errorf("%v\n", err.Error())
+		} else {
+			b.setState(StateConnected)
+			backoff.reset()
+			if err := b.refreshChannels(); err != nil {
+				errorf("We failed to get the bot channels: %v\n", err.Error())
+			}
+			b.startPlugins(ctx)
+			b.catchUp()
+			b.receive(ctx, events)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		b.setState(StateReconnecting)
+		wait := backoff.next()
+		infof("Reconnecting in %v\n", wait)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// receive handles events until the connection drops, a ping fails or the context is canceled.
+func (b *BotKit) receive(ctx context.Context, events <-chan *model.WebSocketEvent) {
+	ticker := time.NewTicker(PING_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				infof("The connection to the server was closed\n")
+				return
+			}
+			b.handleWebsocketEvent(event)
+		case <-ticker.C:
+			if err := b.adapter.Ping(); err != nil {
+				errorf("%v\n", err.Error())
+				b.adapter.Close()
+
+				// let the closing connection finish without blocking
+				go func() {
+					for range events {
+					}
+				}()
+				return
+			}
+		case <-ctx.Done():
+			b.adapter.Close()
+			return
+		}
+	}
+}
+
+// backoff computes exponentially growing waits with jitter.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	attempt uint
+}
+
+func (b *backoff) next() time.Duration {
+	wait := b.min << b.attempt
+	if wait <= 0 || wait > b.max {
+		wait = b.max
+	} else {
+		b.attempt++
+	}
+
+	// wait somewhere between the half and the whole of the duration
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+func (b *backoff) reset() {
+	b.attempt = 0
+}
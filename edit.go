@@ -0,0 +1,26 @@
+package mmbot
+
+import (
+	"fmt"
+
+	"github.com/mattermost/platform/model"
+)
+
+// UpdatePost replaces the text of a post the bot created.
+func (b *BotKit) UpdatePost(handle *PostHandle, text string) error {
+	post := &model.Post{Id: handle.PostId, ChannelId: handle.ChannelId, Message: text}
+	if _, err := b.adapter.UpdatePost(post); err != nil {
+		return fmt.Errorf("We failed to update the post '%s': %v", handle.PostId, err.Error())
+	}
+
+	return nil
+}
+
+// DeletePost deletes a post the bot created.
+func (b *BotKit) DeletePost(handle *PostHandle) error {
+	if err := b.adapter.DeletePost(handle.ChannelId, handle.PostId); err != nil {
+		return fmt.Errorf("We failed to delete the post '%s': %v", handle.PostId, err.Error())
+	}
+
+	return nil
+}
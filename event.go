@@ -0,0 +1,369 @@
+package mmbot
+
+import (
+	"encoding/json"
+
+	"github.com/mattermost/platform/model"
+)
+
+const (
+	// not defined by the model package of older servers
+	WEBSOCKET_EVENT_CHANNEL_VIEWED = "channel_viewed"
+
+	// events waiting for a handler, beyond which they are dropped
+	EVENT_QUEUE_SIZE = 100
+)
+
+// EventHandler handles a websocket event subscribed with On.
+type EventHandler func(event *Event) error
+
+// Event is a websocket event with its payload decoded. It is shared by the handlers, which must not change it.
+type Event struct {
+	Type      string
+	ChannelId string
+	TeamId    string
+	UserId    string
+
+	// Data is the payload, whose type depends on the event:
+	//
+	//	posted, post_edited, post_deleted, ephemeral_message       *PostEvent
+	//	typing                                                     *TypingEvent
+	//	status_change                                              *StatusEvent
+	//	user_added, user_removed, new_user, added_to_team,
+	//	leave_team                                                 *MemberEvent
+	//	channel_created, channel_updated, channel_deleted,
+	//	channel_viewed, direct_added, group_added                  *ChannelEvent
+	//	user_updated                                               *UserEvent
+	//	update_team                                                *TeamEvent
+	//	reaction_added, reaction_removed                           *Reaction
+	//	preference_changed, preferences_changed,
+	//	preferences_deleted                                        *PreferencesEvent
+	//	hello                                                      *HelloEvent
+	//
+	// The payload of any other event is its raw data.
+	Data interface{}
+
+	Raw *model.WebSocketEvent
+
+	// the user who caused the event, to apply the ACL
+	actorId string
+}
+
+type PostEvent struct {
+	Post        *model.Post
+	ChannelType string
+	ChannelName string
+	SenderName  string
+	// ids of the users mentioned in a new post
+	Mentions []string
+}
+
+type TypingEvent struct {
+	UserId    string
+	ChannelId string
+	ParentId  string
+}
+
+type StatusEvent struct {
+	UserId string
+	// online, away or offline
+	Status string
+}
+
+type MemberEvent struct {
+	UserId    string
+	ChannelId string
+	TeamId    string
+	// the user who removed the member, if any
+	RemoverId string
+}
+
+type ChannelEvent struct {
+	ChannelId string
+	TeamId    string
+	// the channel is only sent with channel_updated
+	Channel *model.Channel
+	// the other member of a direct channel, or the members of a group channel
+	TeammateIds []string
+}
+
+type UserEvent struct {
+	User *model.User
+}
+
+type TeamEvent struct {
+	Team *model.Team
+}
+
+type PreferencesEvent struct {
+	Preferences model.Preferences
+}
+
+type HelloEvent struct {
+	ServerVersion string
+}
+
+// On subscribes the handler to the websocket events of the type, such as model.WEBSOCKET_EVENT_POST_EDITED.
+// The handler receives every event of the type the bot user can see, including the ones caused by the bot itself,
+// one at a time and in order. Reactions are only delivered from the other users, in the channels of the bot.
+// The handler belongs to the plugin of the package it is defined in, so that enabled_plugins and the ACL apply to it.
+func (b *BotKit) On(eventType string, handler EventHandler) {
+	sub := &subscription{
+		plugin:  PluginName(handler),
+		handler: handler,
+		events:  make(chan *Event, EVENT_QUEUE_SIZE),
+	}
+
+	b.pluginsMu.Lock()
+	defer b.pluginsMu.Unlock()
+
+	// the bot has shut down
+	if b.subsClosed {
+		return
+	}
+	if b.subscriptions == nil {
+		b.subscriptions = map[string][]*subscription{}
+	}
+	b.subscriptions[eventType] = append(b.subscriptions[eventType], sub)
+
+	go b.runSubscription(sub)
+}
+
+// closeSubscriptions ends the goroutines of the handlers once the bot has shut down.
+func (b *BotKit) closeSubscriptions() {
+	b.pluginsMu.Lock()
+	defer b.pluginsMu.Unlock()
+
+	if b.subsClosed {
+		return
+	}
+	b.subsClosed = true
+	for _, subs := range b.subscriptions {
+		for _, sub := range subs {
+			close(sub.events)
+		}
+	}
+}
+
+// subscription delivers the events to a handler in order.
+type subscription struct {
+	plugin  string
+	handler EventHandler
+	events  chan *Event
+}
+
+func (b *BotKit) runSubscription(sub *subscription) {
+	for event := range sub.events {
+		// the post is read here rather than in the loop reading the websocket
+		if reaction, ok := event.Data.(*Reaction); ok {
+			b.resolveReaction(reaction)
+		}
+		b.handleEvent(sub, event)
+		b.inflight.Done()
+	}
+}
+
+// emit queues the event for the handlers subscribed to its type, whose plugin is enabled and allows the user of the event.
+func (b *BotKit) emit(event *model.WebSocketEvent) {
+	b.pluginsMu.RLock()
+	subs := append([]*subscription{}, b.subscriptions[event.Event]...)
+	b.pluginsMu.RUnlock()
+
+	if len(subs) == 0 {
+		return
+	}
+
+	config := b.Config()
+	e := decodeEvent(event)
+
+	// the bot's own reactions, such as msg.React, would come back to its handlers
+	if reaction, ok := e.Data.(*Reaction); ok && (reaction.UserId == b.User.Id || !b.IsMember(reaction.ChannelId)) {
+		return
+	}
+
+	// the ACL does not apply to the bot itself
+	if e.actorId == b.User.Id {
+		e.actorId = ""
+	}
+
+	var username string
+	if e.actorId != "" {
+		if user, err := b.getUser(e.actorId); err != nil {
+			errorf("We cannnot get user by id: %s\n", e.actorId)
+		} else {
+			username = user.Username
+		}
+	}
+
+	// the channels of the handlers are not closed while the event is queued
+	b.pluginsMu.RLock()
+	defer b.pluginsMu.RUnlock()
+	if b.subsClosed {
+		return
+	}
+
+	for _, sub := range subs {
+		if !config.PluginEnabled(sub.plugin) || (e.actorId != "" && !config.ACL.Allows(sub.plugin, username)) {
+			continue
+		}
+
+		b.inflight.Add(1)
+		select {
+		case sub.events <- e:
+		default:
+			b.inflight.Done()
+			errorf("We dropped the event '%s' for the plugin '%s', which is too slow to handle the events\n", e.Type, sub.plugin)
+		}
+	}
+}
+
+func (b *BotKit) handleEvent(sub *subscription, event *Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			errorf("Plugin '%s' failed to handle the event '%s': panic: %v\n", sub.plugin, event.Type, r)
+		}
+	}()
+
+	if err := sub.handler(event); err != nil {
+		errorf("Plugin '%s' failed to handle the event '%s': %v\n", sub.plugin, event.Type, err.Error())
+	}
+}
+
+func decodeEvent(event *model.WebSocketEvent) *Event {
+	e := &Event{Type: event.Event, Data: event.Data, Raw: event}
+	if event.Broadcast != nil {
+		e.ChannelId = event.Broadcast.ChannelId
+		e.TeamId = event.Broadcast.TeamId
+		e.UserId = event.Broadcast.UserId
+	}
+	if e.ChannelId == "" {
+		e.ChannelId = eventString(event, "channel_id")
+	}
+	if e.TeamId == "" {
+		e.TeamId = eventString(event, "team_id")
+	}
+
+	switch event.Event {
+	case model.WEBSOCKET_EVENT_POSTED, model.WEBSOCKET_EVENT_POST_EDITED, model.WEBSOCKET_EVENT_POST_DELETED,
+		model.WEBSOCKET_EVENT_EPHEMERAL_MESSAGE:
+		data := &PostEvent{
+			ChannelType: eventString(event, "channel_type"),
+			ChannelName: eventString(event, "channel_name"),
+			SenderName:  eventString(event, "sender_name"),
+		}
+		decodeEventField(event, "post", &data.Post)
+		decodeEventField(event, "mentions", &data.Mentions)
+		if data.Post != nil {
+			e.actorId = data.Post.UserId
+			if e.ChannelId == "" {
+				e.ChannelId = data.Post.ChannelId
+			}
+		}
+		e.Data = data
+	case model.WEBSOCKET_EVENT_TYPING:
+		e.actorId = eventUserId(event)
+		e.Data = &TypingEvent{
+			UserId:    eventUserId(event),
+			ChannelId: e.ChannelId,
+			ParentId:  eventString(event, "parent_id"),
+		}
+	case model.WEBSOCKET_EVENT_STATUS_CHANGE:
+		e.actorId = eventUserId(event)
+		e.Data = &StatusEvent{UserId: eventUserId(event), Status: eventString(event, "status")}
+	case model.WEBSOCKET_EVENT_USER_ADDED, model.WEBSOCKET_EVENT_USER_REMOVED, model.WEBSOCKET_EVENT_NEW_USER,
+		model.WEBSOCKET_EVENT_ADDED_TO_TEAM, model.WEBSOCKET_EVENT_LEAVE_TEAM:
+		e.Data = &MemberEvent{
+			UserId:    eventUserId(event),
+			ChannelId: e.ChannelId,
+			TeamId:    e.TeamId,
+			RemoverId: eventString(event, "remover_id"),
+		}
+	case model.WEBSOCKET_EVENT_CHANNEL_CREATED, WEBSOCKET_EVENT_CHANNEL_UPDATED, model.WEBSOCKET_EVENT_CHANNEL_DELETED,
+		WEBSOCKET_EVENT_CHANNEL_VIEWED, model.WEBSOCKET_EVENT_DIRECT_ADDED, model.WEBSOCKET_EVENT_GROUP_ADDED:
+		data := &ChannelEvent{ChannelId: e.ChannelId, TeamId: e.TeamId}
+		decodeEventField(event, "channel", &data.Channel)
+		if data.Channel != nil && data.ChannelId == "" {
+			data.ChannelId = data.Channel.Id
+			e.ChannelId = data.Channel.Id
+		}
+		if teammateId := eventString(event, "teammate_id"); teammateId != "" {
+			data.TeammateIds = []string{teammateId}
+		}
+		decodeEventField(event, "teammate_ids", &data.TeammateIds)
+		e.Data = data
+	case model.WEBSOCKET_EVENT_USER_UPDATED:
+		data := &UserEvent{}
+		decodeEventField(event, "user", &data.User)
+		e.Data = data
+	case model.WEBSOCKET_EVENT_UPDATE_TEAM:
+		data := &TeamEvent{}
+		decodeEventField(event, "team", &data.Team)
+		e.Data = data
+	case model.WEBSOCKET_EVENT_REACTION_ADDED, model.WEBSOCKET_EVENT_REACTION_REMOVED:
+		reaction := &model.Reaction{}
+		if decodeEventField(event, "reaction", reaction) {
+			e.actorId = reaction.UserId
+		}
+		e.Data = &Reaction{
+			EmojiName: reaction.EmojiName,
+			Added:     event.Event == model.WEBSOCKET_EVENT_REACTION_ADDED,
+			PostId:    reaction.PostId,
+			ChannelId: e.ChannelId,
+			UserId:    reaction.UserId,
+		}
+	case model.WEBSOCKET_EVENT_PREFERENCE_CHANGED:
+		data := &PreferencesEvent{}
+		preference := &model.Preference{}
+		if decodeEventField(event, "preference", preference) {
+			data.Preferences = model.Preferences{*preference}
+		}
+		e.Data = data
+	case model.WEBSOCKET_EVENT_PREFERENCES_CHANGED, model.WEBSOCKET_EVENT_PREFERENCES_DELETED:
+		data := &PreferencesEvent{}
+		decodeEventField(event, "preferences", &data.Preferences)
+		e.Data = data
+	case model.WEBSOCKET_EVENT_HELLO:
+		e.Data = &HelloEvent{ServerVersion: eventString(event, "server_version")}
+	}
+
+	return e
+}
+
+func eventString(event *model.WebSocketEvent, key string) string {
+	val, _ := event.Data[key].(string)
+	return val
+}
+
+// eventUserId returns the user of the event, who is either in the data or the only receiver of the event.
+func eventUserId(event *model.WebSocketEvent) string {
+	if userId := eventString(event, "user_id"); userId != "" {
+		return userId
+	}
+	if event.Broadcast != nil {
+		return event.Broadcast.UserId
+	}
+	return ""
+}
+
+// decodeEventField decodes a field of the event data, which is sent either as a JSON string or as an object.
+func decodeEventField(event *model.WebSocketEvent, key string, v interface{}) bool {
+	val, ok := event.Data[key]
+	if !ok || val == nil {
+		return false
+	}
+
+	var data []byte
+	if s, ok := val.(string); ok {
+		data = []byte(s)
+	} else if encoded, err := json.Marshal(val); err != nil {
+		return false
+	} else {
+		data = encoded
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		debugf("We failed to decode '%s' of the event '%s': %v\n", key, event.Event, err.Error())
+		return false
+	}
+	return true
+}
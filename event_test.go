@@ -0,0 +1,153 @@
+package mmbot
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/platform/model"
+)
+
+func TestDecodePostEvent(t *testing.T) {
+	post := &model.Post{Id: model.NewId(), UserId: "alice", ChannelId: "town", Message: "hello"}
+	event := model.NewWebSocketEvent(model.WEBSOCKET_EVENT_POSTED, "team", "", "", nil)
+	event.Add("post", post.ToJson())
+	event.Add("channel_type", model.CHANNEL_OPEN)
+	event.Add("sender_name", "alice")
+	event.Add("mentions", `["bot"]`)
+
+	e := decodeEvent(event)
+	data, ok := e.Data.(*PostEvent)
+	if !ok {
+		t.Fatalf("expected a PostEvent, got %T", e.Data)
+	}
+	if data.Post == nil || data.Post.Id != post.Id || data.Post.Message != "hello" {
+		t.Fatalf("unexpected post %v", data.Post)
+	}
+	if data.ChannelType != model.CHANNEL_OPEN || data.SenderName != "alice" || len(data.Mentions) != 1 || data.Mentions[0] != "bot" {
+		t.Fatalf("unexpected payload %+v", data)
+	}
+
+	// the channel comes from the post when the broadcast has none
+	if e.ChannelId != "town" || e.TeamId != "team" || e.actorId != "alice" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+}
+
+func TestDecodeStatusEvent(t *testing.T) {
+	event := model.NewWebSocketEvent(model.WEBSOCKET_EVENT_STATUS_CHANGE, "", "", "alice", nil)
+	event.Add("status", model.STATUS_AWAY)
+
+	if data, ok := decodeEvent(event).Data.(*StatusEvent); !ok {
+		t.Fatalf("expected a StatusEvent, got %T", decodeEvent(event).Data)
+	} else if data.UserId != "alice" || data.Status != model.STATUS_AWAY {
+		t.Fatalf("unexpected payload %+v", data)
+	}
+}
+
+func TestDecodeReactionEvent(t *testing.T) {
+	// the reaction is sent either as a JSON string or as an object
+	reaction := &model.Reaction{UserId: "alice", PostId: "post", EmojiName: "+1"}
+	object := map[string]interface{}{"user_id": "alice", "post_id": "post", "emoji_name": "+1"}
+
+	for _, value := range []interface{}{reaction.ToJson(), object} {
+		event := model.NewWebSocketEvent(model.WEBSOCKET_EVENT_REACTION_REMOVED, "", "town", "", nil)
+		event.Add("reaction", value)
+
+		e := decodeEvent(event)
+		data, ok := e.Data.(*Reaction)
+		if !ok {
+			t.Fatalf("expected a Reaction, got %T", e.Data)
+		}
+		if data.EmojiName != "+1" || data.PostId != "post" || data.UserId != "alice" || data.ChannelId != "town" || data.Added {
+			t.Fatalf("unexpected payload %+v", data)
+		}
+	}
+}
+
+func TestDecodeChannelEvent(t *testing.T) {
+	channel := &model.Channel{Id: model.NewId(), Name: "town-square"}
+	event := model.NewWebSocketEvent(WEBSOCKET_EVENT_CHANNEL_UPDATED, "team", "", "", nil)
+	event.Add("channel", channel.ToJson())
+
+	e := decodeEvent(event)
+	if data, ok := e.Data.(*ChannelEvent); !ok {
+		t.Fatalf("expected a ChannelEvent, got %T", e.Data)
+	} else if data.Channel == nil || data.Channel.Name != "town-square" || data.ChannelId != channel.Id {
+		t.Fatalf("unexpected payload %+v", data)
+	}
+	if e.ChannelId != channel.Id {
+		t.Fatalf("expected the channel of the event to be %s, got %s", channel.Id, e.ChannelId)
+	}
+}
+
+func TestDecodeUnknownEvent(t *testing.T) {
+	event := model.NewWebSocketEvent("custom_event", "", "town", "", nil)
+	event.Add("key", "value")
+
+	e := decodeEvent(event)
+	if data, ok := e.Data.(map[string]interface{}); !ok || data["key"] != "value" {
+		t.Fatalf("expected the raw data, got %v", e.Data)
+	}
+	if e.Raw != event || e.ChannelId != "town" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+}
+
+func TestOnInOrder(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	var mu sync.Mutex
+	statuses := []string{}
+	done := make(chan struct{})
+	b.On(model.WEBSOCKET_EVENT_STATUS_CHANGE, func(event *Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		statuses = append(statuses, event.Data.(*StatusEvent).Status)
+		if len(statuses) == 20 {
+			close(done)
+		}
+		return nil
+	})
+
+	for i := 0; i < 20; i++ {
+		event := model.NewWebSocketEvent(model.WEBSOCKET_EVENT_STATUS_CHANGE, "", "", b.User.Id, nil)
+		event.Add("status", fmt.Sprint(i))
+		b.handleWebsocketEvent(event)
+	}
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, status := range statuses {
+		if status != fmt.Sprint(i) {
+			t.Fatalf("expected the events in order, got %v", statuses)
+		}
+	}
+}
+
+func TestCloseSubscriptions(t *testing.T) {
+	b, _ := newTestBot(t)
+	b.On(model.WEBSOCKET_EVENT_STATUS_CHANGE, func(event *Event) error { return nil })
+
+	b.closeSubscriptions()
+	b.closeSubscriptions()
+
+	b.pluginsMu.RLock()
+	sub := b.subscriptions[model.WEBSOCKET_EVENT_STATUS_CHANGE][0]
+	b.pluginsMu.RUnlock()
+	if _, ok := <-sub.events; ok {
+		t.Fatal("expected the channel of the handler to be closed")
+	}
+
+	// the events and the subscriptions after the shutdown are dropped
+	b.handleWebsocketEvent(model.NewWebSocketEvent(model.WEBSOCKET_EVENT_STATUS_CHANGE, "", "", b.User.Id, nil))
+	b.On(model.WEBSOCKET_EVENT_STATUS_CHANGE, func(event *Event) error { return nil })
+
+	b.pluginsMu.RLock()
+	defer b.pluginsMu.RUnlock()
+	if n := len(b.subscriptions[model.WEBSOCKET_EVENT_STATUS_CHANGE]); n != 1 {
+		t.Fatalf("expected no new subscription, got %d", n)
+	}
+}
@@ -0,0 +1,175 @@
+package mmbot
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	HEAR_INTERVAL = 10 * time.Second
+)
+
+// Listener hears every message in the channels of the bot, whether or not it is addressed to the bot.
+// It returns true if it acted on the message, which starts its rate limit in the channel.
+// Listeners are registered with AddListener, separately from the handlers of commands.
+type Listener interface {
+	Hear(msg *Message) (bool, error)
+}
+
+// HearConfig keeps listeners from becoming noisy.
+type HearConfig struct {
+	// minimum interval between the messages a listener acts on in a channel
+	Interval time.Duration `yaml:"interval"`
+	// channels where listeners hear, or all channels of the bot if empty
+	Channels        []string `yaml:"channels"`
+	ExcludeChannels []string `yaml:"exclude_channels"`
+}
+
+// Hears returns true if listeners hear the messages in the channel.
+func (c HearConfig) Hears(channelName string) bool {
+	for _, excluded := range c.ExcludeChannels {
+		if excluded == channelName {
+			return false
+		}
+	}
+
+	if len(c.Channels) == 0 {
+		return true
+	}
+	for _, channel := range c.Channels {
+		if channel == channelName {
+			return true
+		}
+	}
+	return false
+}
+
+// HearFunc handles a message matched by a pattern registered with Router.HearPattern.
+// match holds the text of the leftmost match and its submatches.
+type HearFunc func(msg *Message, match []string) error
+
+type hearing struct {
+	pattern *regexp.Regexp
+	handler HearFunc
+}
+
+// HearPattern registers a handler for the messages matching the regular expression.
+// It panics if the pattern is invalid. Add the router with AddListener to receive the messages.
+func (r *Router) HearPattern(pattern string, handler HearFunc) {
+	r.hearings = append(r.hearings, &hearing{regexp.MustCompile(pattern), handler})
+}
+
+// Hear runs the first pattern matching the message.
+func (r *Router) Hear(msg *Message) (bool, error) {
+	for _, h := range r.hearings {
+		if match := h.pattern.FindStringSubmatch(msg.Text); match != nil {
+			msg.TopLevel = msg.TopLevel || r.topLevel
+			return true, h.handler(msg, match)
+		}
+	}
+	return false, nil
+}
+
+// AddListener registers a listener for every message in the channels of the bot.
+func (b *BotKit) AddListener(listener Listener) {
+	b.pluginsMu.Lock()
+	defer b.pluginsMu.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+// Listeners returns the listeners which are enabled in the configuration.
+func (b *BotKit) Listeners() []Listener {
+	config := b.Config()
+
+	b.pluginsMu.RLock()
+	defer b.pluginsMu.RUnlock()
+
+	listeners := []Listener{}
+	for _, listener := range b.listeners {
+		if config.PluginEnabled(PluginName(listener)) {
+			listeners = append(listeners, listener)
+		}
+	}
+	return listeners
+}
+
+// hearLimiter remembers when each listener last acted in each channel.
+type hearLimiter struct {
+	mu   sync.Mutex
+	last map[hearKey]time.Time
+}
+
+type hearKey struct {
+	listener  Listener
+	channelId string
+}
+
+// allow reserves the turn of the listener in the channel if its interval is over,
+// so that concurrent messages cannot all pass before one of them is heard.
+// It returns the time of the previous turn, to give the turn back with release.
+func (l *hearLimiter) allow(listener Listener, channelId string, interval time.Duration) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := hearKey{listener, channelId}
+	last := l.last[key]
+	if time.Since(last) < interval {
+		return last, false
+	}
+
+	if l.last == nil {
+		l.last = map[hearKey]time.Time{}
+	}
+	l.last[key] = time.Now()
+	return last, true
+}
+
+// release gives the turn back when the listener did not act on the message.
+func (l *hearLimiter) release(listener Listener, channelId string, last time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.last[hearKey{listener, channelId}] = last
+}
+
+// hear delivers the message to the listeners which are not rate limited in the channel.
+// Middlewares are not applied, as they are meant for commands.
+func (b *BotKit) hear(msg *Message, listeners []Listener) {
+	config := b.Config()
+
+	wg := &sync.WaitGroup{}
+	for _, listener := range listeners {
+		name := PluginName(listener)
+		if !config.ACL.Allows(name, msg.Username) {
+			continue
+		}
+		last, ok := b.hearLimiter.allow(listener, msg.ChannelId, config.Hear.Interval)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(listener Listener, name string, last time.Time) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errorf("Listener '%s' failed to hear '%s': panic: %v\n", name, msg.Text, r)
+				}
+			}()
+
+			// each listener gets its own copy, so that it can change its reply options
+			m := *msg
+			heard, err := listener.Hear(&m)
+			if !heard {
+				b.hearLimiter.release(listener, msg.ChannelId, last)
+			}
+
+			if err != nil {
+				errorf("Listener '%s' failed to hear '%s': %v\n", name, msg.Text, err.Error())
+			} else if heard {
+				debugf("Listener '%s' heard '%s'\n", name, msg.Text)
+			}
+		}(listener, name, last)
+	}
+	wg.Wait()
+}
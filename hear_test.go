@@ -0,0 +1,98 @@
+package mmbot
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingListener counts the messages it hears, and acts on them unless told to skip.
+type countingListener struct {
+	mu    sync.Mutex
+	calls int
+	heard int
+	skip  int
+}
+
+func (l *countingListener) Hear(msg *Message) (bool, error) {
+	// overlap with the other messages of a burst
+	time.Sleep(10 * time.Millisecond)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.calls++
+	if l.skip > 0 {
+		l.skip--
+		return false, nil
+	}
+	l.heard++
+	return true, nil
+}
+
+func (l *countingListener) counts() (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.calls, l.heard
+}
+
+func TestHearRateLimit(t *testing.T) {
+	b, _ := newTestBot(t)
+	listener := &countingListener{}
+	msg := newTestMessage(t, b, "hello")
+
+	// only one message of a concurrent burst is heard
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.hear(msg, []Listener{listener})
+		}()
+	}
+	wg.Wait()
+
+	if calls, heard := listener.counts(); calls != 1 || heard != 1 {
+		t.Fatalf("expected a single message heard, got %d calls and %d heard", calls, heard)
+	}
+
+	// the limit is per channel
+	other := *msg
+	other.ChannelId = "other"
+	b.hear(&other, []Listener{listener})
+	if _, heard := listener.counts(); heard != 2 {
+		t.Fatalf("expected the message in another channel to be heard, got %d", heard)
+	}
+}
+
+func TestHearReleasesTurn(t *testing.T) {
+	b, _ := newTestBot(t)
+	listener := &countingListener{skip: 1}
+	msg := newTestMessage(t, b, "hello")
+
+	// a message the listener does not act on leaves its turn to the next one
+	b.hear(msg, []Listener{listener})
+	b.hear(msg, []Listener{listener})
+	b.hear(msg, []Listener{listener})
+
+	if calls, heard := listener.counts(); calls != 2 || heard != 1 {
+		t.Fatalf("expected 2 calls and 1 heard, got %d and %d", calls, heard)
+	}
+}
+
+func TestHearLimiterInterval(t *testing.T) {
+	l := &hearLimiter{}
+	listener := &countingListener{}
+
+	if _, ok := l.allow(listener, "a", 20*time.Millisecond); !ok {
+		t.Fatal("expected the first turn to be allowed")
+	}
+	if _, ok := l.allow(listener, "a", 20*time.Millisecond); ok {
+		t.Fatal("expected the second turn to wait for the interval")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := l.allow(listener, "a", 20*time.Millisecond); !ok {
+		t.Fatal("expected a turn after the interval")
+	}
+}
@@ -0,0 +1,445 @@
+package mmbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/mattermost/platform/model"
+)
+
+// InMemoryAdapter is a fake chat server living in the bot process.
+// It is useful to test plugins without a real Mattermost server.
+type InMemoryAdapter struct {
+	mu        sync.Mutex
+	user      *model.User
+	team      *model.Team
+	users     map[string]*model.User
+	channels  map[string]*model.Channel
+	posts     []*model.Post
+	history   []*model.Post
+	reactions []*model.Reaction
+
+	// guards the connection, which is closed by Close and opened again by Listen
+	connMu sync.Mutex
+	conn   *inMemoryConn
+	closed bool
+}
+
+// inMemoryConn is the channel of events of a connection.
+type inMemoryConn struct {
+	events chan *model.WebSocketEvent
+	// closed first, so that the senders waiting for room give up
+	done    chan struct{}
+	senders sync.WaitGroup
+}
+
+func newInMemoryConn() *inMemoryConn {
+	return &inMemoryConn{
+		events: make(chan *model.WebSocketEvent, 100),
+		done:   make(chan struct{}),
+	}
+}
+
+func NewInMemoryAdapter(username, teamname string) *InMemoryAdapter {
+	a := &InMemoryAdapter{
+		team:     &model.Team{Id: model.NewId(), Name: teamname, DisplayName: teamname},
+		users:    map[string]*model.User{},
+		channels: map[string]*model.Channel{},
+		conn:     newInMemoryConn(),
+	}
+	a.user = a.AddUser(username)
+	return a
+}
+
+// AddUser registers a user who can talk to the bot.
+func (a *InMemoryAdapter) AddUser(username string) *model.User {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	user := &model.User{Id: model.NewId(), Username: username}
+	a.users[user.Id] = user
+	return user
+}
+
+// AddChannel creates a channel the bot is a member of.
+func (a *InMemoryAdapter) AddChannel(channelName string) *model.Channel {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	channel := &model.Channel{Id: model.NewId(), TeamId: a.team.Id, Name: channelName, DisplayName: channelName, Type: model.CHANNEL_OPEN}
+	a.channels[channel.Id] = channel
+	return channel
+}
+
+// Receive simulates a message posted by the user in the channel.
+func (a *InMemoryAdapter) Receive(username, channelName, text string) (*model.Post, error) {
+	a.mu.Lock()
+	user := a.findUser(username)
+	channel := a.findChannel(channelName)
+	a.mu.Unlock()
+
+	if user == nil {
+		return nil, fmt.Errorf("User '%s' is not found", username)
+	}
+
+	if channel == nil {
+		return nil, fmt.Errorf("Channel '%s' is not found", channelName)
+	}
+
+	post := &model.Post{UserId: user.Id, ChannelId: channel.Id, Message: text}
+	post.PreSave()
+
+	a.mu.Lock()
+	a.history = append(a.history, post)
+	a.mu.Unlock()
+
+	event := model.NewWebSocketEvent(model.WEBSOCKET_EVENT_POSTED, a.team.Id, channel.Id, "", nil)
+	event.Add("post", post.ToJson())
+	event.Add("channel_type", channel.Type)
+	if err := a.Emit(event); err != nil {
+		return nil, err
+	}
+
+	return post, nil
+}
+
+// React simulates an emoji reaction of the user on the post, or its removal.
+func (a *InMemoryAdapter) React(username, postId, emojiName string, added bool) error {
+	a.mu.Lock()
+	user := a.findUser(username)
+	post := a.findPost(postId)
+	a.mu.Unlock()
+
+	if user == nil {
+		return fmt.Errorf("User '%s' is not found", username)
+	}
+
+	if post == nil {
+		return fmt.Errorf("Post '%s' is not found", postId)
+	}
+
+	reaction := &model.Reaction{UserId: user.Id, PostId: post.Id, EmojiName: emojiName, CreateAt: model.GetMillis()}
+	if added {
+		return a.SaveReaction(post.ChannelId, reaction)
+	}
+	return a.DeleteReaction(post.ChannelId, reaction)
+}
+
+// Reactions returns the reactions on the post.
+func (a *InMemoryAdapter) Reactions(postId string) []*model.Reaction {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	reactions := []*model.Reaction{}
+	for _, reaction := range a.reactions {
+		if reaction.PostId == postId {
+			reactions = append(reactions, reaction)
+		}
+	}
+	return reactions
+}
+
+// Posts returns the messages the bot has sent so far.
+func (a *InMemoryAdapter) Posts() []*model.Post {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	posts := make([]*model.Post, len(a.posts))
+	copy(posts, a.posts)
+	return posts
+}
+
+func (a *InMemoryAdapter) Connect() (*model.User, *model.Team, error) {
+	return a.user, a.team, nil
+}
+
+// Emit simulates an event sent by the server, such as a status change.
+// It waits for room in the channel of events, unless the connection is closed.
+func (a *InMemoryAdapter) Emit(event *model.WebSocketEvent) error {
+	a.connMu.Lock()
+	if a.closed {
+		a.connMu.Unlock()
+		return fmt.Errorf("The connection is closed")
+	}
+	conn := a.conn
+	conn.senders.Add(1)
+	a.connMu.Unlock()
+	defer conn.senders.Done()
+
+	select {
+	case conn.events <- event:
+		return nil
+	case <-conn.done:
+		return fmt.Errorf("The connection is closed")
+	}
+}
+
+func (a *InMemoryAdapter) Listen() (<-chan *model.WebSocketEvent, error) {
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+
+	// reconnect after Close
+	if a.closed {
+		a.conn = newInMemoryConn()
+		a.closed = false
+	}
+	return a.conn.events, nil
+}
+
+func (a *InMemoryAdapter) Ping() error {
+	return nil
+}
+
+// Close closes the channel of events, as the server closes the websocket.
+func (a *InMemoryAdapter) Close() {
+	a.connMu.Lock()
+	if a.closed {
+		a.connMu.Unlock()
+		return
+	}
+	conn := a.conn
+	a.closed = true
+	close(conn.done)
+	a.connMu.Unlock()
+
+	// the events cannot be closed while a sender may still send them
+	conn.senders.Wait()
+	close(conn.events)
+}
+
+func (a *InMemoryAdapter) GetChannels() ([]*model.Channel, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	channels := []*model.Channel{}
+	for _, channel := range a.channels {
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+func (a *InMemoryAdapter) GetChannel(channelId string) (*model.Channel, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if channel, ok := a.channels[channelId]; ok {
+		return channel, nil
+	}
+	return nil, fmt.Errorf("Channel '%s' is not found", channelId)
+}
+
+func (a *InMemoryAdapter) GetChannelByName(channelName string) (*model.Channel, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if channel := a.findChannel(channelName); channel != nil {
+		return channel, nil
+	}
+	return nil, fmt.Errorf("Channel '%s' is not found", channelName)
+}
+
+func (a *InMemoryAdapter) GetDirectChannel(userId string) (*model.Channel, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.users[userId]; !ok {
+		return nil, fmt.Errorf("User '%s' is not found", userId)
+	}
+
+	channelName := model.GetDMNameFromIds(a.user.Id, userId)
+	if channel := a.findChannel(channelName); channel != nil {
+		return channel, nil
+	}
+
+	channel := &model.Channel{Id: model.NewId(), Name: channelName, Type: model.CHANNEL_DIRECT}
+	a.channels[channel.Id] = channel
+	return channel, nil
+}
+
+func (a *InMemoryAdapter) GetUser(userId string) (*model.User, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if user, ok := a.users[userId]; ok {
+		return user, nil
+	}
+	return nil, fmt.Errorf("User '%s' is not found", userId)
+}
+
+func (a *InMemoryAdapter) GetPostsSince(channelId string, since int64) ([]*model.Post, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	posts := []*model.Post{}
+	for _, post := range a.history {
+		if post.ChannelId == channelId && post.CreateAt > since {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
+
+func (a *InMemoryAdapter) GetPost(channelId, postId string) (*model.Post, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if post := a.findPost(postId); post != nil && post.ChannelId == channelId {
+		found := *post
+		return &found, nil
+	}
+	return nil, fmt.Errorf("Post '%s' is not found", postId)
+}
+
+func (a *InMemoryAdapter) CreatePost(post *model.Post) (*model.Post, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.channels[post.ChannelId]; !ok {
+		return nil, fmt.Errorf("Channel '%s' is not found", post.ChannelId)
+	}
+
+	created := *post
+	created.UserId = a.user.Id
+	created.PreSave()
+	a.posts = append(a.posts, &created)
+	a.history = append(a.history, &created)
+	return &created, nil
+}
+
+func (a *InMemoryAdapter) UpdatePost(post *model.Post) (*model.Post, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, p := range a.history {
+		if p.Id == post.Id && p.DeleteAt == 0 {
+			p.Message = post.Message
+			p.EditAt = model.GetMillis()
+			p.UpdateAt = p.EditAt
+			updated := *p
+			return &updated, nil
+		}
+	}
+	return nil, fmt.Errorf("Post '%s' is not found", post.Id)
+}
+
+func (a *InMemoryAdapter) DeletePost(channelId, postId string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, p := range a.history {
+		if p.Id == postId && p.ChannelId == channelId && p.DeleteAt == 0 {
+			p.DeleteAt = model.GetMillis()
+			return nil
+		}
+	}
+	return fmt.Errorf("Post '%s' is not found", postId)
+}
+
+func (a *InMemoryAdapter) SaveReaction(channelId string, reaction *model.Reaction) error {
+	a.mu.Lock()
+	if post := a.findPost(reaction.PostId); post == nil || post.ChannelId != channelId {
+		a.mu.Unlock()
+		return fmt.Errorf("Post '%s' is not found", reaction.PostId)
+	}
+
+	saved := *reaction
+	if saved.UserId == "" {
+		saved.UserId = a.user.Id
+	}
+	for _, r := range a.reactions {
+		if r.UserId == saved.UserId && r.PostId == saved.PostId && r.EmojiName == saved.EmojiName {
+			a.mu.Unlock()
+			return nil
+		}
+	}
+	a.reactions = append(a.reactions, &saved)
+	a.mu.Unlock()
+
+	return a.emitReaction(model.WEBSOCKET_EVENT_REACTION_ADDED, channelId, &saved)
+}
+
+func (a *InMemoryAdapter) DeleteReaction(channelId string, reaction *model.Reaction) error {
+	userId := reaction.UserId
+	if userId == "" {
+		userId = a.user.Id
+	}
+
+	a.mu.Lock()
+	for i, r := range a.reactions {
+		if r.UserId == userId && r.PostId == reaction.PostId && r.EmojiName == reaction.EmojiName {
+			a.reactions = append(a.reactions[:i], a.reactions[i+1:]...)
+			a.mu.Unlock()
+
+			return a.emitReaction(model.WEBSOCKET_EVENT_REACTION_REMOVED, channelId, r)
+		}
+	}
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *InMemoryAdapter) emitReaction(eventType, channelId string, reaction *model.Reaction) error {
+	event := model.NewWebSocketEvent(eventType, "", channelId, "", nil)
+	event.Add("reaction", reaction.ToJson())
+	return a.Emit(event)
+}
+
+func (a *InMemoryAdapter) PostToWebhook(webhook, payload string) error {
+	form, err := url.ParseQuery(payload)
+	if err != nil {
+		return err
+	}
+
+	message := &model.IncomingWebhookRequest{}
+	if err := json.Unmarshal([]byte(form.Get("payload")), message); err != nil {
+		return err
+	}
+
+	channel, err := a.GetChannelByName(message.ChannelName)
+	if err != nil {
+		return err
+	}
+
+	post := &model.Post{ChannelId: channel.Id, Message: message.Text}
+	for key, value := range message.Props {
+		post.AddProp(key, value)
+	}
+	if len(message.Attachments) > 0 {
+		post.AddProp("attachments", message.Attachments)
+	}
+	post.AddProp("override_username", message.Username)
+	if message.IconURL != "" {
+		post.AddProp("override_icon_url", message.IconURL)
+	}
+
+	_, err = a.CreatePost(post)
+	return err
+}
+
+func (a *InMemoryAdapter) findUser(username string) *model.User {
+	for _, user := range a.users {
+		if user.Username == username {
+			return user
+		}
+	}
+	return nil
+}
+
+func (a *InMemoryAdapter) findPost(postId string) *model.Post {
+	for _, post := range a.history {
+		if post.Id == postId && post.DeleteAt == 0 {
+			return post
+		}
+	}
+	return nil
+}
+
+func (a *InMemoryAdapter) findChannel(channelName string) *model.Channel {
+	for _, channel := range a.channels {
+		if channel.Name == channelName {
+			return channel
+		}
+	}
+	return nil
+}
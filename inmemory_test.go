@@ -0,0 +1,261 @@
+package mmbot
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/platform/model"
+)
+
+// testAdapter is the in-memory server, extended by the tests to inject failures.
+type testAdapter struct {
+	*InMemoryAdapter
+
+	mu sync.Mutex
+	// the number of posts to fail with 503
+	failures int
+	// the number of channel lookups to fail with 503
+	channelFailures int
+	// create the failed posts anyway, as a server timing out after saving them
+	createOnFailure bool
+	updates         int
+}
+
+func (a *testAdapter) CreatePost(post *model.Post) (*model.Post, error) {
+	a.mu.Lock()
+	fail := a.failures > 0
+	if fail {
+		a.failures--
+	}
+	a.mu.Unlock()
+
+	if !fail {
+		return a.InMemoryAdapter.CreatePost(post)
+	}
+	if a.createOnFailure {
+		a.InMemoryAdapter.CreatePost(post)
+	}
+	return nil, model.NewAppError("CreatePost", "test.unavailable", nil, "", 503)
+}
+
+func (a *testAdapter) GetChannel(channelId string) (*model.Channel, error) {
+	a.mu.Lock()
+	fail := a.channelFailures > 0
+	if fail {
+		a.channelFailures--
+	}
+	a.mu.Unlock()
+
+	if fail {
+		return nil, model.NewAppError("GetChannel", "test.unavailable", nil, "", 503)
+	}
+	return a.InMemoryAdapter.GetChannel(channelId)
+}
+
+func (a *testAdapter) UpdatePost(post *model.Post) (*model.Post, error) {
+	a.mu.Lock()
+	a.updates++
+	a.mu.Unlock()
+	return a.InMemoryAdapter.UpdatePost(post)
+}
+
+func (a *testAdapter) updateCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.updates
+}
+
+// newTestBot returns a bot connected to an in-memory server with the user "alice" and the channel "town-square".
+func newTestBot(t *testing.T) (*BotKit, *testAdapter) {
+	dir, err := ioutil.TempDir("", "mmbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adapter := &testAdapter{InMemoryAdapter: NewInMemoryAdapter("bot", "team")}
+	adapter.AddUser("alice")
+	adapter.AddChannel("town-square")
+
+	config := &Config{
+		LevelDBPath: dir,
+		LogLevel:    "error",
+		CatchUp:     CatchUpConfig{MaxAge: CATCHUP_MAX_AGE},
+		Hear:        HearConfig{Interval: HEAR_INTERVAL},
+		Queue: QueueConfig{
+			Interval:        time.Millisecond,
+			ChannelInterval: time.Millisecond,
+			MaxRetries:      QUEUE_MAX_RETRIES,
+			Size:            QUEUE_SIZE,
+		},
+		Plugins: map[string]interface{}{},
+	}
+
+	b := NewBotKitWithConfig(adapter, config)
+	t.Cleanup(func() {
+		b.closeSubscriptions()
+		b.stopQueue()
+		b.Memory.Close()
+		os.RemoveAll(dir)
+	})
+	return b, adapter
+}
+
+func TestInMemoryAdapterClose(t *testing.T) {
+	adapter := NewInMemoryAdapter("bot", "team")
+	adapter.AddUser("alice")
+	adapter.AddChannel("town-square")
+
+	events, err := adapter.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := adapter.Receive("alice", "town-square", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	adapter.Close()
+	adapter.Close()
+
+	if event, ok := <-events; !ok || event.Event != model.WEBSOCKET_EVENT_POSTED {
+		t.Fatalf("expected the posted event before the close, got %v", event)
+	}
+	if _, ok := <-events; ok {
+		t.Fatal("expected the events to be closed")
+	}
+	if _, err := adapter.Receive("alice", "town-square", "lost"); err == nil {
+		t.Fatal("expected an error on a closed connection")
+	}
+
+	// listening again reconnects
+	events, err = adapter.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := adapter.Receive("alice", "town-square", "again"); err != nil {
+		t.Fatal(err)
+	}
+	if event := <-events; event.Event != model.WEBSOCKET_EVENT_POSTED {
+		t.Fatalf("expected a posted event, got '%s'", event.Event)
+	}
+}
+
+func TestReceiveStopsWhenAdapterCloses(t *testing.T) {
+	b, adapter := newTestBot(t)
+
+	events, err := adapter.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		b.receive(b.ctx, events)
+		close(done)
+	}()
+
+	adapter.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("receive did not return after the connection closed")
+	}
+}
+
+// newTestPost returns a post from alice in town-square, as if posted to the server.
+func newTestPost(t *testing.T, b *BotKit, text string) *model.Post {
+	channel, err := b.getChannelByName("town-square")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := b.adapter.(*testAdapter)
+	a.InMemoryAdapter.mu.Lock()
+	user := a.findUser("alice")
+	a.InMemoryAdapter.mu.Unlock()
+
+	post := &model.Post{UserId: user.Id, ChannelId: channel.Id, Message: text}
+	post.PreSave()
+	return post
+}
+
+// newTestMessage returns a message from alice in town-square, as if posted to the server.
+func newTestMessage(t *testing.T, b *BotKit, text string) *Message {
+	post := newTestPost(t, b, text)
+	channel, _ := b.getChannel(post.ChannelId)
+	user, _ := b.getUser(post.UserId)
+	return newMessage(b, text, post, channel, user)
+}
+
+// recordingHandler records the text of the messages it handles.
+type recordingHandler struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func (h *recordingHandler) Handle(msg *Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.texts = append(h.texts, msg.Text)
+	return nil
+}
+
+func (h *recordingHandler) Usage() string { return "" }
+
+func (h *recordingHandler) handled() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string{}, h.texts...)
+}
+
+// waitFor polls the condition until it holds, or fails the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInMemoryAdapterCloseWhileFull(t *testing.T) {
+	adapter := NewInMemoryAdapter("bot", "team")
+	adapter.AddUser("alice")
+	adapter.AddChannel("town-square")
+
+	// nobody reads the events, so that the senders wait for room
+	errs := make(chan error, 110)
+	for i := 0; i < 110; i++ {
+		go func() {
+			_, err := adapter.Receive("alice", "town-square", "hello")
+			errs <- err
+		}()
+	}
+	waitFor(t, "the events to fill up", func() bool { return len(adapter.conn.events) == cap(adapter.conn.events) })
+
+	closed := make(chan struct{})
+	go func() {
+		adapter.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close deadlocked with the senders waiting")
+	}
+
+	failed := 0
+	for i := 0; i < 110; i++ {
+		if err := <-errs; err != nil {
+			failed++
+		}
+	}
+	if failed != 10 {
+		t.Fatalf("expected the 10 senders without room to fail, got %d", failed)
+	}
+}
@@ -0,0 +1,232 @@
+package mmbot
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	SHUTDOWN_TIMEOUT = 10 * time.Second
+)
+
+// Plugins may implement the following interfaces to take part in the lifecycle of the bot.
+// A plugin failing to initialize or start is disabled and reported by Health.
+
+// Initializer is called when the bot starts running, before connecting to the server.
+type Initializer interface {
+	Init() error
+}
+
+// Starter is called once the bot has connected to the server for the first time.
+// Scheduled work, such as cron tasks, should start here.
+type Starter interface {
+	Start(ctx context.Context) error
+}
+
+// Stopper is implemented by plugins which have to release resources, such as schedulers, on shutdown.
+// The context expires when the shutdown timeout is over.
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+// HealthChecker reports whether the plugin is working.
+type HealthChecker interface {
+	Health() error
+}
+
+// RunContext runs the bot until the context is canceled, then shuts it down gracefully.
+func (b *BotKit) RunContext(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	b.ctx = ctx
+
+	b.initPlugins()
+
+	// listen to the chat server until the context is canceled
+	b.listen(ctx)
+	infof("Shutting down the bot\n")
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancelShutdown()
+
+	b.drain(shutdownCtx)
+	b.closeSubscriptions()
+	b.stopPlugins(shutdownCtx)
+
+	// send the replies and the last messages of plugins
+	b.flushQueue(shutdownCtx)
+	b.stopQueue()
+
+	if err := b.Memory.Close(); err != nil {
+		errorf("We failed to close level db: %v\n", err.Error())
+	}
+}
+
+// drain waits for the messages still being handled by plugins.
+func (b *BotKit) drain(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		b.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errorf("We gave up waiting for the plugins handling messages\n")
+	}
+}
+
+func (b *BotKit) initPlugins() {
+	for _, plugin := range b.Plugins() {
+		b.initPlugin(plugin)
+	}
+}
+
+// startPlugins starts the plugins once the bot is connected for the first time.
+func (b *BotKit) startPlugins(ctx context.Context) {
+	b.started.Do(func() {
+		b.pluginsMu.Lock()
+		b.pluginsCtx = ctx
+		pending := b.pendingStart
+		b.pendingStart = nil
+		b.pluginsMu.Unlock()
+
+		// the plugins enabled by a reload before the first connection are queued
+		plugins := b.Plugins()
+		enabled := map[Handler]bool{}
+		for _, plugin := range plugins {
+			enabled[plugin] = true
+		}
+		for _, plugin := range pending {
+			if !enabled[plugin] && b.Config().PluginEnabled(PluginName(plugin)) {
+				enabled[plugin] = true
+				plugins = append(plugins, plugin)
+			}
+		}
+
+		for _, plugin := range plugins {
+			b.startPlugin(ctx, plugin)
+		}
+	})
+}
+
+func (b *BotKit) stopPlugins(ctx context.Context) {
+	for _, plugin := range b.runningPlugins() {
+		b.stopPlugin(ctx, plugin)
+	}
+}
+
+func (b *BotKit) initPlugin(plugin Handler) bool {
+	b.pluginsMu.Lock()
+	initialized := b.initialized[plugin]
+	b.initialized[plugin] = true
+	b.pluginsMu.Unlock()
+
+	if initializer, ok := pluginOf(plugin).(Initializer); ok && !initialized {
+		if err := initializer.Init(); err != nil {
+			b.disablePlugin(plugin, fmt.Errorf("failed to initialize: %v", err.Error()))
+			return false
+		}
+	}
+	return true
+}
+
+func (b *BotKit) startPlugin(ctx context.Context, plugin Handler) {
+	b.pluginsMu.RLock()
+	running := b.running[plugin]
+	b.pluginsMu.RUnlock()
+	if running {
+		return
+	}
+
+	// a plugin enabled by reloading the configuration has not been initialized yet
+	if !b.initPlugin(plugin) {
+		return
+	}
+
+	if starter, ok := pluginOf(plugin).(Starter); ok {
+		if err := starter.Start(ctx); err != nil {
+			b.disablePlugin(plugin, fmt.Errorf("failed to start: %v", err.Error()))
+			return
+		}
+	}
+
+	b.pluginsMu.Lock()
+	defer b.pluginsMu.Unlock()
+	b.running[plugin] = true
+}
+
+func (b *BotKit) stopPlugin(ctx context.Context, plugin Handler) {
+	if stopper, ok := pluginOf(plugin).(Stopper); ok {
+		if err := stopper.Stop(ctx); err != nil {
+			errorf("Plugin %T failed to stop: %v\n", pluginOf(plugin), err.Error())
+		}
+	}
+
+	b.pluginsMu.Lock()
+	defer b.pluginsMu.Unlock()
+	delete(b.running, plugin)
+}
+
+func (b *BotKit) runningPlugins() []Handler {
+	b.pluginsMu.RLock()
+	defer b.pluginsMu.RUnlock()
+
+	plugins := []Handler{}
+	for _, plugin := range b.plugins {
+		if b.running[plugin] {
+			plugins = append(plugins, plugin)
+		}
+	}
+	return plugins
+}
+
+// Health returns the problem of each plugin by its type name, or nil if the plugin is healthy.
+func (b *BotKit) Health() map[string]error {
+	config := b.Config()
+
+	b.pluginsMu.RLock()
+	defer b.pluginsMu.RUnlock()
+
+	health := map[string]error{}
+	for _, plugin := range b.plugins {
+		name := fmt.Sprintf("%T", pluginOf(plugin))
+		if err, ok := b.failures[plugin]; ok {
+			health[name] = err
+		} else if !config.PluginEnabled(PluginName(plugin)) {
+			health[name] = fmt.Errorf("not enabled")
+		} else if checker, ok := pluginOf(plugin).(HealthChecker); ok {
+			health[name] = checker.Health()
+		} else {
+			health[name] = nil
+		}
+	}
+	return health
+}
+
+// Plugins returns the plugins which are handling messages.
+// Plugins which failed or are not in the enable list of the configuration are excluded.
+func (b *BotKit) Plugins() []Handler {
+	config := b.Config()
+
+	b.pluginsMu.RLock()
+	defer b.pluginsMu.RUnlock()
+
+	plugins := []Handler{}
+	for _, plugin := range b.plugins {
+		if _, failed := b.failures[plugin]; !failed && config.PluginEnabled(PluginName(plugin)) {
+			plugins = append(plugins, plugin)
+		}
+	}
+	return plugins
+}
+
+func (b *BotKit) disablePlugin(plugin Handler, err error) {
+	errorf("Plugin %T %v. It is disabled.\n", pluginOf(plugin), err.Error())
+
+	b.pluginsMu.Lock()
+	defer b.pluginsMu.Unlock()
+	b.failures[plugin] = err
+}
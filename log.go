@@ -0,0 +1,74 @@
+package mmbot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+type LogLevel int32
+
+const (
+	LOG_DEBUG LogLevel = iota
+	LOG_INFO
+	LOG_ERROR
+)
+
+var logLevel = int32(LOG_INFO)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LOG_DEBUG:
+		return "debug"
+	case LOG_INFO:
+		return "info"
+	case LOG_ERROR:
+		return "error"
+	default:
+		return fmt.Sprintf("LogLevel(%d)", int32(l))
+	}
+}
+
+// ParseLogLevel converts "debug", "info" or "error" to the log level.
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return LOG_DEBUG, nil
+	case "info", "":
+		return LOG_INFO, nil
+	case "error":
+		return LOG_ERROR, nil
+	default:
+		return LOG_INFO, fmt.Errorf("unknown log level '%s'", level)
+	}
+}
+
+// SetLogLevel hides the logs below the level. It can be changed while the bot is running.
+func SetLogLevel(level LogLevel) {
+	atomic.StoreInt32(&logLevel, int32(level))
+}
+
+// Logf logs the message if the level is not hidden by the log level of the bot.
+// Plugins use it to follow the log_level setting.
+func Logf(level LogLevel, format string, v ...interface{}) {
+	logf(level, format, v...)
+}
+
+func logf(level LogLevel, format string, v ...interface{}) {
+	if level >= LogLevel(atomic.LoadInt32(&logLevel)) {
+		log.Printf(format, v...)
+	}
+}
+
+func debugf(format string, v ...interface{}) {
+	logf(LOG_DEBUG, format, v...)
+}
+
+func infof(format string, v ...interface{}) {
+	logf(LOG_INFO, format, v...)
+}
+
+func errorf(format string, v ...interface{}) {
+	logf(LOG_ERROR, format, v...)
+}
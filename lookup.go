@@ -0,0 +1,88 @@
+package mmbot
+
+import (
+	"github.com/mattermost/platform/model"
+)
+
+// getUser returns the user by id from the cache, or from the server.
+func (b *BotKit) getUser(userId string) (*model.User, error) {
+	if user, ok := b.userCache.Get(userId); ok {
+		return user.(*model.User), nil
+	}
+
+	user, err := b.adapter.GetUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	b.userCache.Set(userId, user)
+	return user, nil
+}
+
+// getChannel returns the channel by id, looking up the channels of the bot and the cache first.
+func (b *BotKit) getChannel(channelId string) (*model.Channel, error) {
+	b.channelsMu.RLock()
+	channel, ok := b.channels[channelId]
+	b.channelsMu.RUnlock()
+
+	if ok {
+		return channel, nil
+	}
+
+	if channel, ok := b.channelCache.Get("id:" + channelId); ok {
+		return channel.(*model.Channel), nil
+	}
+
+	channel, err := b.adapter.GetChannel(channelId)
+	if err != nil {
+		return nil, err
+	}
+
+	b.cacheChannel(channel)
+	return channel, nil
+}
+
+// getChannelByName returns the channel by name, looking up the channels of the bot and the cache first.
+func (b *BotKit) getChannelByName(channelName string) (*model.Channel, error) {
+	b.channelsMu.RLock()
+	for _, channel := range b.channels {
+		if channel.Name == channelName {
+			b.channelsMu.RUnlock()
+			return channel, nil
+		}
+	}
+	b.channelsMu.RUnlock()
+
+	if channel, ok := b.channelCache.Get("name:" + channelName); ok {
+		return channel.(*model.Channel), nil
+	}
+
+	channel, err := b.adapter.GetChannelByName(channelName)
+	if err != nil {
+		return nil, err
+	}
+
+	b.cacheChannel(channel)
+	return channel, nil
+}
+
+func (b *BotKit) cacheChannel(channel *model.Channel) {
+	b.channelCache.Set("id:"+channel.Id, channel)
+	b.channelCache.Set("name:"+channel.Name, channel)
+}
+
+func (b *BotKit) invalidateChannel(channelId string) {
+	if channel, ok := b.channelCache.Get("id:" + channelId); ok {
+		b.channelCache.Delete("name:" + channel.(*model.Channel).Name)
+	}
+	b.channelCache.Delete("id:" + channelId)
+}
+
+// handleUserUpdatedEvent drops the updated user from the cache.
+func (b *BotKit) handleUserUpdatedEvent(event *model.WebSocketEvent) {
+	if user, ok := event.Data["user"].(map[string]interface{}); ok {
+		if userId, ok := user["id"].(string); ok {
+			b.userCache.Delete(userId)
+		}
+	}
+}
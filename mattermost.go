@@ -0,0 +1,333 @@
+package mmbot
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mattermost/platform/model"
+)
+
+const (
+	// how long to wait for the websocket to answer a ping
+	WEBSOCKET_PING_TIMEOUT = 10 * time.Second
+)
+
+type MattermostAdapter struct {
+	client   *model.Client
+	wsClient *model.WebSocketClient
+	user     *model.User
+
+	account  string
+	password string
+	token    string
+	teamname string
+}
+
+func NewMattermostAdapter(endpoint, account, password, teamname string) *MattermostAdapter {
+	return &MattermostAdapter{
+		client:   model.NewClient(endpoint),
+		account:  account,
+		password: password,
+		teamname: teamname,
+	}
+}
+
+// NewMattermostAdapterWithToken authenticates with a personal access token or the token of a bot account
+// instead of a password, which also works when MFA or SSO is enforced.
+func NewMattermostAdapterWithToken(endpoint, token, teamname string) *MattermostAdapter {
+	return &MattermostAdapter{
+		client:   model.NewClient(endpoint),
+		token:    token,
+		teamname: teamname,
+	}
+}
+
+func (a *MattermostAdapter) Connect() (*model.User, *model.Team, error) {
+	var user *model.User
+	var team *model.Team
+
+	// confirm the mattermost server is alive
+	if props, err := a.client.GetPing(); err != nil {
+		return nil, nil, fmt.Errorf("There was a problem pinging the Mattermost server '%s': %v", a.client.Url, err.Error())
+	} else {
+		infof("Server detected and is running version %s\n", props["version"])
+	}
+
+	// login to the mattermost server
+	if err := a.login(); err != nil {
+		return nil, nil, err
+	} else {
+		user = a.user
+	}
+
+	// login to the mattermost team
+	if result, err := a.client.GetInitialLoad(); err != nil {
+		return nil, nil, fmt.Errorf("We failed to get the initial load: %v", err.Error())
+	} else {
+		initialLoad := result.Data.(*model.InitialLoad)
+		for _, t := range initialLoad.Teams {
+			if t.Name == a.teamname {
+				team = t
+				break
+			}
+		}
+
+		if team == nil {
+			return nil, nil, fmt.Errorf("We do not appear to be a member of the team '%s'", a.teamname)
+		}
+
+		a.client.SetTeamId(team.Id)
+	}
+
+	// confirm the bot can work in the team
+	if err := a.checkPermissions(team); err != nil {
+		return nil, nil, err
+	}
+
+	return user, team, nil
+}
+
+func (a *MattermostAdapter) Listen() (<-chan *model.WebSocketEvent, error) {
+	// login again if the session has expired while disconnected
+	if _, err := a.client.GetMe(""); err != nil {
+		if err.StatusCode != http.StatusUnauthorized {
+			return nil, fmt.Errorf("There was a problem getting the bot user: %v", err.Error())
+		}
+
+		infof("The session has expired. Try to login again.\n")
+		if err := a.login(); err != nil {
+			return nil, err
+		}
+	}
+
+	wsUrl, _ := url.Parse(a.client.Url)
+	wsUrl.Scheme = "ws"
+
+	// create websocket
+	wsClient, err := model.NewWebSocketClient(wsUrl.String(), a.client.AuthToken)
+	if err != nil {
+		return nil, fmt.Errorf("We failed to connect to the websocket '%s': %v", wsUrl.String(), err.Error())
+	} else {
+		infof("Listening to websocket '%s'\n", wsUrl.String())
+	}
+
+	// start listening to websocket
+	a.wsClient = wsClient
+	a.wsClient.Listen()
+	return a.wsClient.EventChannel, nil
+}
+
+func (a *MattermostAdapter) Ping() error {
+	if _, err := a.client.GetPing(); err != nil {
+		return fmt.Errorf("There was a problem pinging the Mattermost server '%s': %v", a.client.Url, err.Error())
+	}
+
+	return a.pingWebsocket()
+}
+
+// pingWebsocket sends a request over the websocket and waits for its response,
+// as a half-open websocket receives nothing while the server still answers HTTP requests.
+func (a *MattermostAdapter) pingWebsocket() error {
+	if a.wsClient == nil {
+		return fmt.Errorf("The websocket is not connected")
+	}
+
+	seq := a.wsClient.Sequence
+	a.wsClient.GetStatuses()
+
+	timeout := time.After(WEBSOCKET_PING_TIMEOUT)
+	for {
+		select {
+		case response, ok := <-a.wsClient.ResponseChannel:
+			if !ok {
+				return fmt.Errorf("The websocket was closed")
+			}
+			// skip the responses to the earlier requests
+			if response.SeqReply == seq {
+				return nil
+			}
+		case <-timeout:
+			return fmt.Errorf("The websocket did not answer the ping in %v", WEBSOCKET_PING_TIMEOUT)
+		}
+	}
+}
+
+func (a *MattermostAdapter) Close() {
+	if a.wsClient != nil {
+		a.wsClient.Close()
+	}
+}
+
+func (a *MattermostAdapter) login() error {
+	if a.token != "" {
+		return a.loginWithToken()
+	}
+
+	if result, err := a.client.Login(a.account, a.password); err != nil {
+		return fmt.Errorf("There was a problem logging into the Mattermost server: %v", err.Error())
+	} else {
+		a.user = result.Data.(*model.User)
+	}
+
+	return nil
+}
+
+func (a *MattermostAdapter) loginWithToken() error {
+	a.client.AuthToken = a.token
+	a.client.AuthType = model.HEADER_BEARER
+
+	// confirm the token is valid
+	if result, err := a.client.GetMe(""); err != nil {
+		if err.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("The access token is invalid or has been revoked: %v", err.Error())
+		}
+		return fmt.Errorf("There was a problem logging into the Mattermost server with the access token: %v", err.Error())
+	} else {
+		a.user = result.Data.(*model.User)
+	}
+
+	return nil
+}
+
+func (a *MattermostAdapter) checkPermissions(team *model.Team) error {
+	if a.user.DeleteAt != 0 {
+		return fmt.Errorf("The bot account '%s' is deactivated", a.user.Username)
+	}
+
+	if !a.user.IsInRole(model.ROLE_SYSTEM_USER.Id) {
+		return fmt.Errorf("The bot account '%s' does not have the role '%s'", a.user.Username, model.ROLE_SYSTEM_USER.Id)
+	}
+
+	if result, err := a.client.GetTeamMember(team.Id, a.user.Id); err != nil {
+		return fmt.Errorf("The bot account '%s' cannot read its membership of the team '%s': %v", a.user.Username, team.Name, err.Error())
+	} else if member := result.Data.(*model.TeamMember); member == nil || member.DeleteAt != 0 {
+		return fmt.Errorf("The bot account '%s' has left the team '%s'", a.user.Username, team.Name)
+	}
+
+	return nil
+}
+
+func (a *MattermostAdapter) GetChannels() ([]*model.Channel, error) {
+	if r, err := a.client.DoApiGet(fmt.Sprintf("/teams/%v/channels/", a.client.GetTeamId()), "", ""); err != nil {
+		return nil, err
+	} else {
+		defer r.Body.Close()
+		return model.ChannelSliceFromJson(r.Body), nil
+	}
+}
+
+func (a *MattermostAdapter) GetChannel(channelId string) (*model.Channel, error) {
+	if result, err := a.client.GetChannel(channelId, ""); err != nil {
+		return nil, err
+	} else {
+		return result.Data.(*model.ChannelData).Channel, nil
+	}
+}
+
+func (a *MattermostAdapter) GetChannelByName(channelName string) (*model.Channel, error) {
+	if result, err := a.client.GetChannelByName(channelName); err != nil {
+		return nil, err
+	} else {
+		return result.Data.(*model.Channel), nil
+	}
+}
+
+func (a *MattermostAdapter) GetDirectChannel(userId string) (*model.Channel, error) {
+	// reuse the direct channel if it already exists
+	if channel, err := a.GetChannelByName(model.GetDMNameFromIds(a.user.Id, userId)); err == nil {
+		return channel, nil
+	}
+
+	if result, err := a.client.CreateDirectChannel(userId); err != nil {
+		return nil, err
+	} else {
+		return result.Data.(*model.Channel), nil
+	}
+}
+
+func (a *MattermostAdapter) GetUser(userId string) (*model.User, error) {
+	if result, err := a.client.GetUser(userId, ""); err != nil {
+		return nil, err
+	} else {
+		return result.Data.(*model.User), nil
+	}
+}
+
+func (a *MattermostAdapter) GetPostsSince(channelId string, since int64) ([]*model.Post, error) {
+	if result, err := a.client.GetPostsSince(channelId, since); err != nil {
+		return nil, err
+	} else {
+		list := result.Data.(*model.PostList)
+
+		// the order of the post list is newest first
+		posts := []*model.Post{}
+		for i := len(list.Order) - 1; i >= 0; i-- {
+			if post, ok := list.Posts[list.Order[i]]; ok {
+				posts = append(posts, post)
+			}
+		}
+		return posts, nil
+	}
+}
+
+func (a *MattermostAdapter) GetPost(channelId, postId string) (*model.Post, error) {
+	if result, err := a.client.GetPost(channelId, postId, ""); err != nil {
+		return nil, err
+	} else {
+		list := result.Data.(*model.PostList)
+		if post, ok := list.Posts[postId]; ok {
+			return post, nil
+		}
+		return nil, fmt.Errorf("Post '%s' is not found", postId)
+	}
+}
+
+func (a *MattermostAdapter) CreatePost(post *model.Post) (*model.Post, error) {
+	if result, err := a.client.CreatePost(post); err != nil {
+		return nil, err
+	} else {
+		return result.Data.(*model.Post), nil
+	}
+}
+
+func (a *MattermostAdapter) UpdatePost(post *model.Post) (*model.Post, error) {
+	if result, err := a.client.UpdatePost(post); err != nil {
+		return nil, err
+	} else {
+		return result.Data.(*model.Post), nil
+	}
+}
+
+func (a *MattermostAdapter) DeletePost(channelId, postId string) error {
+	if _, err := a.client.DeletePost(channelId, postId); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (a *MattermostAdapter) SaveReaction(channelId string, reaction *model.Reaction) error {
+	if _, err := a.client.SaveReaction(channelId, reaction); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (a *MattermostAdapter) DeleteReaction(channelId string, reaction *model.Reaction) error {
+	if err := a.client.DeleteReaction(channelId, reaction); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (a *MattermostAdapter) PostToWebhook(webhook, payload string) error {
+	if _, err := a.client.PostToWebhook(webhook, payload); err != nil {
+		return err
+	}
+
+	return nil
+}
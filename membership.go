@@ -0,0 +1,128 @@
+package mmbot
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mattermost/platform/model"
+)
+
+const (
+	// not defined by the model package of older servers
+	WEBSOCKET_EVENT_CHANNEL_UPDATED = "channel_updated"
+)
+
+// Channels returns the channels the bot is a member of.
+func (b *BotKit) Channels() []*model.Channel {
+	b.channelsMu.RLock()
+	defer b.channelsMu.RUnlock()
+
+	channels := []*model.Channel{}
+	for _, channel := range b.channels {
+		channels = append(channels, channel)
+	}
+
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Name < channels[j].Name
+	})
+	return channels
+}
+
+// IsMember returns true if the bot is a member of the channel.
+func (b *BotKit) IsMember(channelId string) bool {
+	b.channelsMu.RLock()
+	defer b.channelsMu.RUnlock()
+
+	_, ok := b.channels[channelId]
+	return ok
+}
+
+// refreshChannels fetches the channels of the bot again, as they may have changed while disconnected.
+func (b *BotKit) refreshChannels() error {
+	channels, err := b.adapter.GetChannels()
+	if err != nil {
+		return err
+	}
+
+	joined := map[string]bool{}
+	for _, channel := range channels {
+		b.joinChannel(channel)
+		joined[channel.Id] = true
+	}
+
+	// direct and group messages are not listed in the team channels
+	for _, channel := range b.Channels() {
+		if !joined[channel.Id] && !channel.IsGroupOrDirect() {
+			b.leaveChannel(channel.Id)
+		}
+	}
+	return nil
+}
+
+func (b *BotKit) joinChannel(channel *model.Channel) {
+	b.channelsMu.Lock()
+	defer b.channelsMu.Unlock()
+
+	if _, ok := b.channels[channel.Id]; !ok {
+		infof("Join the channel '%s'\n", channel.Name)
+	}
+	b.channels[channel.Id] = channel
+}
+
+func (b *BotKit) leaveChannel(channelId string) {
+	b.channelsMu.Lock()
+	defer b.channelsMu.Unlock()
+
+	if channel, ok := b.channels[channelId]; ok {
+		infof("Leave the channel '%s'\n", channel.Name)
+		delete(b.channels, channelId)
+	}
+}
+
+// handleMembershipEvent keeps the channels of the bot up to date.
+func (b *BotKit) handleMembershipEvent(event *model.WebSocketEvent) {
+	channelId, _ := event.Data["channel_id"].(string)
+	if channelId == "" && event.Broadcast != nil {
+		channelId = event.Broadcast.ChannelId
+	}
+
+	userId, _ := event.Data["user_id"].(string)
+	if userId == "" && event.Broadcast != nil {
+		userId = event.Broadcast.UserId
+	}
+
+	switch event.Event {
+	case model.WEBSOCKET_EVENT_USER_ADDED:
+		if userId == b.User.Id {
+			b.fetchChannel(channelId)
+		}
+	case model.WEBSOCKET_EVENT_USER_REMOVED:
+		if userId == b.User.Id {
+			b.leaveChannel(channelId)
+		}
+	case model.WEBSOCKET_EVENT_CHANNEL_CREATED:
+		// the event is only sent to the creator of the channel
+		b.fetchChannel(channelId)
+	case model.WEBSOCKET_EVENT_DIRECT_ADDED, model.WEBSOCKET_EVENT_GROUP_ADDED:
+		b.fetchChannel(channelId)
+	case model.WEBSOCKET_EVENT_CHANNEL_DELETED:
+		b.invalidateChannel(channelId)
+		b.leaveChannel(channelId)
+	case WEBSOCKET_EVENT_CHANNEL_UPDATED:
+		data, _ := event.Data["channel"].(string)
+		if channel := model.ChannelFromJson(strings.NewReader(data)); channel != nil {
+			b.invalidateChannel(channel.Id)
+			if b.IsMember(channel.Id) {
+				b.joinChannel(channel)
+			}
+		}
+	}
+}
+
+func (b *BotKit) fetchChannel(channelId string) {
+	if channel, err := b.adapter.GetChannel(channelId); err != nil {
+		errorf("We cannnot get channel by id: %s\n", channelId)
+	} else {
+		b.joinChannel(channel)
+	}
+}
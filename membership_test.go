@@ -0,0 +1,91 @@
+package mmbot
+
+import (
+	"testing"
+
+	"github.com/mattermost/platform/model"
+)
+
+func TestMembershipEvents(t *testing.T) {
+	b, adapter := newTestBot(t)
+	aliceId := newTestPost(t, b, "").UserId
+	channel := adapter.AddChannel("off-topic")
+	if b.IsMember(channel.Id) {
+		t.Fatal("expected the new channel not to be joined yet")
+	}
+
+	// another user added to the channel does not change the bot channels
+	event := model.NewWebSocketEvent(model.WEBSOCKET_EVENT_USER_ADDED, "", channel.Id, "", nil)
+	event.Add("user_id", aliceId)
+	b.handleWebsocketEvent(event)
+	if b.IsMember(channel.Id) {
+		t.Fatal("expected the channel not to be joined for another user")
+	}
+
+	event = model.NewWebSocketEvent(model.WEBSOCKET_EVENT_USER_ADDED, "", channel.Id, "", nil)
+	event.Add("user_id", b.User.Id)
+	b.handleWebsocketEvent(event)
+	if !b.IsMember(channel.Id) {
+		t.Fatal("expected the channel to be joined")
+	}
+
+	// the new name of the channel is known right away
+	updated := *channel
+	updated.Name = "random"
+	event = model.NewWebSocketEvent(WEBSOCKET_EVENT_CHANNEL_UPDATED, "", channel.Id, "", nil)
+	event.Add("channel", updated.ToJson())
+	b.handleWebsocketEvent(event)
+	if found, err := b.getChannel(channel.Id); err != nil || found.Name != "random" {
+		t.Fatalf("expected the channel to be renamed, got %v", found)
+	}
+
+	event = model.NewWebSocketEvent(model.WEBSOCKET_EVENT_USER_REMOVED, "", channel.Id, "", nil)
+	event.Add("user_id", b.User.Id)
+	b.handleWebsocketEvent(event)
+	if b.IsMember(channel.Id) {
+		t.Fatal("expected the channel to be left")
+	}
+}
+
+func TestChannelDeleted(t *testing.T) {
+	b, _ := newTestBot(t)
+	channel, _ := b.getChannelByName("town-square")
+
+	event := model.NewWebSocketEvent(model.WEBSOCKET_EVENT_CHANNEL_DELETED, "", channel.Id, "", nil)
+	event.Add("channel_id", channel.Id)
+	b.handleWebsocketEvent(event)
+	if b.IsMember(channel.Id) {
+		t.Fatal("expected the deleted channel to be left")
+	}
+
+	// the channels listed by the server are joined again on reconnection
+	if err := b.refreshChannels(); err != nil {
+		t.Fatal(err)
+	}
+	if !b.IsMember(channel.Id) {
+		t.Fatal("expected the channel to be joined on refresh")
+	}
+}
+
+func TestDirectMessageJoined(t *testing.T) {
+	b, adapter := newTestBot(t)
+	aliceId := newTestPost(t, b, "").UserId
+	channel, err := adapter.GetDirectChannel(aliceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := model.NewWebSocketEvent(model.WEBSOCKET_EVENT_DIRECT_ADDED, "", channel.Id, "", nil)
+	b.handleWebsocketEvent(event)
+	if !b.IsMember(channel.Id) {
+		t.Fatal("expected the direct channel to be joined")
+	}
+
+	// direct channels are not listed with the team channels, but they are kept on refresh
+	if err := b.refreshChannels(); err != nil {
+		t.Fatal(err)
+	}
+	if !b.IsMember(channel.Id) {
+		t.Fatal("expected the direct channel to be kept")
+	}
+}
@@ -24,6 +24,10 @@ func NewMemory() (*Memory, error) {
 		storage = LEVELDB_PATH
 	}
 
+	return NewMemoryWithPath(storage)
+}
+
+func NewMemoryWithPath(storage string) (*Memory, error) {
 	db, err := leveldb.OpenFile(storage, nil)
 	if err != nil {
 		return nil, err
@@ -32,7 +36,7 @@ func NewMemory() (*Memory, error) {
 	return &Memory{db}, nil
 }
 
-func (m *Memory) Get(plugin Plugin, key string) (string, error) {
+func (m *Memory) Get(plugin interface{}, key string) (string, error) {
 	ns_key := fmt.Sprintf("%s:%s", m.namespace(plugin), key)
 	if val, err := m.db.Get([]byte(ns_key), nil); err != nil {
 		return "", err
@@ -41,7 +45,7 @@ func (m *Memory) Get(plugin Plugin, key string) (string, error) {
 	}
 }
 
-func (m *Memory) Put(plugin Plugin, key string, val string) error {
+func (m *Memory) Put(plugin interface{}, key string, val string) error {
 	ns_key := fmt.Sprintf("%s:%s", m.namespace(plugin), key)
 	if err := m.db.Put([]byte(ns_key), []byte(val), nil); err != nil {
 		return err
@@ -50,7 +54,7 @@ func (m *Memory) Put(plugin Plugin, key string, val string) error {
 	}
 }
 
-func (m *Memory) Del(plugin Plugin, key string) (string, error) {
+func (m *Memory) Del(plugin interface{}, key string) (string, error) {
 	var val string
 	if tmp, err := m.Get(plugin, key); err != nil {
 		return "", err
@@ -66,7 +70,7 @@ func (m *Memory) Del(plugin Plugin, key string) (string, error) {
 	}
 }
 
-func (m *Memory) List(plugin Plugin) (map[string]string, error) {
+func (m *Memory) List(plugin interface{}) (map[string]string, error) {
 	list := map[string]string{}
 
 	ns_prefix := fmt.Sprintf("%s:", m.namespace(plugin))
@@ -86,7 +90,11 @@ func (m *Memory) List(plugin Plugin) (map[string]string, error) {
 	}
 }
 
-func (m *Memory) namespace(plugin Plugin) string {
+func (m *Memory) Close() error {
+	return m.db.Close()
+}
+
+func (m *Memory) namespace(plugin interface{}) string {
 	namespace := reflect.TypeOf(plugin).String()
 	return strings.ToUpper(namespace)
 }
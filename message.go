@@ -0,0 +1,121 @@
+package mmbot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mattermost/platform/model"
+)
+
+// Handler receives the messages addressed to the bot.
+type Handler interface {
+	Handle(msg *Message) error
+	Usage() string
+}
+
+// Message is a command sent to the bot, with everything known about the post that carried it.
+type Message struct {
+	// Text is the command text without the bot mention.
+	Text string
+
+	PostId      string
+	RootId      string
+	ChannelId   string
+	Channel     string
+	ChannelType string
+	UserId      string
+	Username    string
+	TeamId      string
+	FileIds     []string
+	Props       map[string]interface{}
+
+	// TopLevel makes the replies new posts in the channel instead of replies in the thread.
+	TopLevel bool
+
+	Post    *model.Post
+	bot     *BotKit
+	ctx     context.Context
+	handled bool
+}
+
+func newMessage(bot *BotKit, text string, post *model.Post, channel *model.Channel, user *model.User) *Message {
+	return &Message{
+		Text:        text,
+		PostId:      post.Id,
+		RootId:      post.RootId,
+		ChannelId:   post.ChannelId,
+		Channel:     channel.Name,
+		ChannelType: channel.Type,
+		UserId:      user.Id,
+		Username:    user.Username,
+		TeamId:      bot.Team.Id,
+		FileIds:     post.FileIds,
+		Props:       post.Props,
+		Post:        post,
+		bot:         bot,
+		ctx:         bot.ctx,
+	}
+}
+
+// MarkHandled tells the bot the plugin acted on the message, so that Logger reports it.
+// Router marks the messages matching its commands, and plugins with the old Plugin interface mark every message they do not fail.
+func (m *Message) MarkHandled() {
+	m.handled = true
+}
+
+// Context is canceled when the bot is shutting down.
+func (m *Message) Context() context.Context {
+	return m.ctx
+}
+
+// IsDirect returns true if the message was sent in a direct or group message.
+func (m *Message) IsDirect() bool {
+	return m.ChannelType == model.CHANNEL_DIRECT || m.ChannelType == model.CHANNEL_GROUP
+}
+
+// ThreadId returns the id of the thread the message belongs to.
+func (m *Message) ThreadId() string {
+	if m.RootId != "" {
+		return m.RootId
+	}
+	return m.PostId
+}
+
+// React reacts to the message with the emoji, for example to acknowledge a command without a reply.
+func (m *Message) React(emojiName string) error {
+	return m.bot.AddReaction(&PostHandle{PostId: m.PostId, ChannelId: m.ChannelId}, emojiName)
+}
+
+// Reply posts the text in the thread of the message, or in the channel if TopLevel is set.
+func (m *Message) Reply(text string) error {
+	return m.bot.Reply(m, text, "", "")
+}
+
+// ReplyInChannel posts the text as a new post in the channel of the message.
+func (m *Message) ReplyInChannel(text string) error {
+	return m.bot.Send(&OutgoingMessage{Text: text, Channel: m.Channel, ChannelId: m.ChannelId})
+}
+
+// ReplyDirect sends the text to the sender in a direct message.
+func (m *Message) ReplyDirect(text string) error {
+	channel, err := m.bot.adapter.GetDirectChannel(m.UserId)
+	if err != nil {
+		return fmt.Errorf("We failed to open a direct channel with '%s': %v", m.Username, err.Error())
+	}
+
+	return m.bot.Send(&OutgoingMessage{Text: text, Channel: channel.Name, ChannelId: channel.Id})
+}
+
+// pluginHandler lets a Plugin with the old HandleMessage(text, channel, username) signature work as a Handler.
+type pluginHandler struct {
+	Plugin
+}
+
+// Handle marks the message as handled unless the plugin fails, as an old plugin cannot tell whether it acted on the message.
+func (h *pluginHandler) Handle(msg *Message) error {
+	if err := h.HandleMessage(msg.Text, msg.Channel, msg.Username); err != nil {
+		return err
+	}
+	msg.MarkHandled()
+	return nil
+}
@@ -0,0 +1,159 @@
+package mmbot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of a plugin handling a message.
+type Result struct {
+	Handler  Handler
+	Err      error
+	Duration time.Duration
+	// Handled is true if the plugin acted on the message, see Message.MarkHandled.
+	Handled bool
+}
+
+// DispatchFunc delivers a message to the plugins and returns their results.
+type DispatchFunc func(msg *Message) []*Result
+
+// Middleware wraps the dispatch of every message. It can inspect or rewrite the message,
+// stop the dispatch by returning without calling next, and inspect the results of next.
+type Middleware func(next DispatchFunc) DispatchFunc
+
+// Logger logs how each plugin handled the message, skipping the plugins which ignored it.
+func Logger() Middleware {
+	return func(next DispatchFunc) DispatchFunc {
+		return func(msg *Message) []*Result {
+			results := next(msg)
+			for _, result := range results {
+				if result.Err != nil {
+					errorf("Plugin %T failed to handle '%s' in %v: %v\n", pluginOf(result.Handler), msg.Text, result.Duration, result.Err.Error())
+				} else if result.Handled {
+					infof("Plugin %T handled '%s' in %v\n", pluginOf(result.Handler), msg.Text, result.Duration)
+				}
+			}
+			return results
+		}
+	}
+}
+
+// Recover keeps a panic in the following middlewares from stopping the bot.
+// Panics in plugins are always recovered and reported as their result error.
+func Recover() Middleware {
+	return func(next DispatchFunc) DispatchFunc {
+		return func(msg *Message) (results []*Result) {
+			defer func() {
+				if r := recover(); r != nil {
+					errorf("Recovered from panic while handling '%s': %v\n", msg.Text, r)
+					results = nil
+				}
+			}()
+			return next(msg)
+		}
+	}
+}
+
+// AllowUsers only lets the listed users talk to the bot.
+func AllowUsers(usernames ...string) Middleware {
+	allowed := map[string]bool{}
+	for _, username := range usernames {
+		allowed[username] = true
+	}
+
+	return func(next DispatchFunc) DispatchFunc {
+		return func(msg *Message) []*Result {
+			if !allowed[msg.Username] {
+				infof("User '%s' is not allowed to send commands\n", msg.Username)
+				return nil
+			}
+			return next(msg)
+		}
+	}
+}
+
+// RateLimit ignores the commands a user sends within the interval after the previous one.
+func RateLimit(interval time.Duration) Middleware {
+	var mu sync.Mutex
+	last := map[string]time.Time{}
+
+	return func(next DispatchFunc) DispatchFunc {
+		return func(msg *Message) []*Result {
+			mu.Lock()
+			now := time.Now()
+			limited := now.Sub(last[msg.UserId]) < interval
+			if !limited {
+				last[msg.UserId] = now
+			}
+			mu.Unlock()
+
+			if limited {
+				infof("User '%s' is rate limited\n", msg.Username)
+				return nil
+			}
+			return next(msg)
+		}
+	}
+}
+
+func (b *BotKit) Use(middlewares ...Middleware) {
+	b.middlewares = append(b.middlewares, middlewares...)
+}
+
+// dispatch sends the message through the middleware chain to every plugin.
+func (b *BotKit) dispatch(msg *Message) []*Result {
+	dispatch := b.dispatchPlugins
+	for i := len(b.middlewares) - 1; i >= 0; i-- {
+		dispatch = b.middlewares[i](dispatch)
+	}
+	return dispatch(msg)
+}
+
+func (b *BotKit) dispatchPlugins(msg *Message) []*Result {
+	acl := b.Config().ACL
+
+	plugins := []Handler{}
+	for _, plugin := range b.Plugins() {
+		if name := PluginName(plugin); !acl.Allows(name, msg.Username) {
+			infof("User '%s' is not allowed to use the plugin '%s'\n", msg.Username, name)
+			continue
+		}
+		plugins = append(plugins, plugin)
+	}
+	results := make([]*Result, len(plugins))
+
+	wg := &sync.WaitGroup{}
+	for i, plugin := range plugins {
+		wg.Add(1)
+		go func(i int, h Handler) {
+			defer wg.Done()
+
+			start := time.Now()
+			result := &Result{Handler: h}
+			defer func() {
+				if r := recover(); r != nil {
+					result.Err = fmt.Errorf("panic: %v", r)
+				}
+				result.Duration = time.Since(start)
+				results[i] = result
+			}()
+
+			// each plugin gets its own copy, so that it can change its reply options
+			m := *msg
+			result.Err = h.Handle(&m)
+			result.Handled = m.handled
+		}(i, plugin)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// pluginOf returns the plugin behind the handler.
+func pluginOf(h Handler) interface{} {
+	if ph, ok := h.(*pluginHandler); ok {
+		return ph.Plugin
+	}
+	return h
+}
@@ -0,0 +1,163 @@
+package mmbot
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// legacyPlugin implements the old Plugin interface.
+type legacyPlugin struct {
+	err error
+}
+
+func (p *legacyPlugin) HandleMessage(text, channel, username string) error { return p.err }
+func (p *legacyPlugin) Usage() string                                      { return "" }
+
+func TestLegacyPluginHandled(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	for _, test := range []struct {
+		err     error
+		handled bool
+	}{
+		{nil, true},
+		{fmt.Errorf("failed"), false},
+	} {
+		h := &pluginHandler{&legacyPlugin{test.err}}
+		msg := newTestMessage(t, b, "hello")
+		if err := h.Handle(msg); err != test.err {
+			t.Fatalf("expected %v, got %v", test.err, err)
+		}
+		if msg.handled != test.handled {
+			t.Fatalf("expected handled to be %v with %v", test.handled, test.err)
+		}
+	}
+}
+
+// funcHandler handles the messages with a function.
+type funcHandler struct {
+	fn func(msg *Message) error
+}
+
+func (h *funcHandler) Handle(msg *Message) error { return h.fn(msg) }
+func (h *funcHandler) Usage() string             { return "" }
+
+func TestMiddlewareOrder(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	var mu sync.Mutex
+	calls := []string{}
+	record := func(call string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call)
+	}
+
+	trace := func(name string) Middleware {
+		return func(next DispatchFunc) DispatchFunc {
+			return func(msg *Message) []*Result {
+				record(name + " before")
+				results := next(msg)
+				record(name + " after")
+				return results
+			}
+		}
+	}
+	b.Use(trace("first"), trace("second"))
+	b.AddHandler(&funcHandler{func(msg *Message) error {
+		record("plugin")
+		return nil
+	}})
+
+	if results := b.dispatch(newTestMessage(t, b, "hello")); len(results) != 1 {
+		t.Fatalf("expected a result, got %d", len(results))
+	}
+	expected := []string{"first before", "second before", "plugin", "second after", "first after"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	b, _ := newTestBot(t)
+	handler := &recordingHandler{}
+	b.AddHandler(handler)
+	b.Use(AllowUsers("bob"), RateLimit(time.Hour))
+
+	if results := b.dispatch(newTestMessage(t, b, "hello")); results != nil {
+		t.Fatalf("expected the dispatch to stop, got %v", results)
+	}
+	if texts := handler.handled(); len(texts) != 0 {
+		t.Fatalf("expected the plugin not to be called, got %v", texts)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	b, _ := newTestBot(t)
+	handler := &recordingHandler{}
+	b.AddHandler(handler)
+	b.Use(RateLimit(time.Hour))
+
+	b.dispatch(newTestMessage(t, b, "first"))
+	b.dispatch(newTestMessage(t, b, "second"))
+	if texts := handler.handled(); len(texts) != 1 || texts[0] != "first" {
+		t.Fatalf("expected only the first message, got %v", texts)
+	}
+}
+
+// syncBuffer collects the logs written from several goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLogger(t *testing.T) {
+	b, _ := newTestBot(t)
+	b.Use(Logger())
+
+	logs := &syncBuffer{}
+	log.SetOutput(logs)
+	SetLogLevel(LOG_INFO)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		SetLogLevel(LOG_ERROR)
+	}()
+
+	b.AddHandler(&funcHandler{func(msg *Message) error {
+		msg.MarkHandled()
+		return nil
+	}})
+	b.AddHandler(&recordingHandler{})
+	b.AddHandler(&pluginHandler{&legacyPlugin{fmt.Errorf("broken")}})
+	b.dispatch(newTestMessage(t, b, "hello"))
+
+	// the plugin which ignored the message is not logged
+	out := logs.String()
+	if !strings.Contains(out, "Plugin *mmbot.funcHandler handled 'hello'") {
+		t.Errorf("expected the handled message to be logged, got %q", out)
+	}
+	if !strings.Contains(out, "Plugin *mmbot.legacyPlugin failed to handle 'hello'") || !strings.Contains(out, "broken") {
+		t.Errorf("expected the failure to be logged, got %q", out)
+	}
+	if strings.Contains(out, "recordingHandler") {
+		t.Errorf("expected the ignoring plugin not to be logged, got %q", out)
+	}
+}
@@ -0,0 +1,194 @@
+package mmbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattermost/platform/model"
+)
+
+const (
+	// how long the ids of sent messages are remembered to deduplicate them
+	OUTBOX_SENT_TTL = 24 * time.Hour
+
+	// how often the expired ids of sent messages are removed
+	OUTBOX_PRUNE_INTERVAL = 1 * time.Hour
+
+	// the prop marking the posts sent from the outbox
+	OUTBOX_ID_PROP = "mmbot_outbox_id"
+)
+
+// The outbox keeps the queued messages in Memory until they are sent or given up,
+// so that the messages pending when the bot stopped are sent when it starts again.
+
+// outboxEntry is a message in the outbox, numbered in the order it was queued,
+// as the parts of a split message usually share the same QueuedAt.
+type outboxEntry struct {
+	*OutgoingMessage
+	OutboxSeq int64
+}
+
+// saveOutbox writes the message to the outbox. It returns false if the message is in the outbox or has been sent already.
+func (b *BotKit) saveOutbox(out *OutgoingMessage) (bool, error) {
+	// concurrent messages with the same id must not both pass
+	b.outboxMu.Lock()
+	defer b.outboxMu.Unlock()
+
+	if val, err := b.Memory.Get(b, "outbox_sent:"+out.Id); err == nil {
+		if !sentExpired(val) {
+			return false, nil
+		}
+		b.Memory.Del(b, "outbox_sent:"+out.Id)
+	}
+	if _, err := b.Memory.Get(b, "outbox:"+out.Id); err == nil {
+		return false, nil
+	}
+
+	data, err := json.Marshal(&outboxEntry{out, atomic.AddInt64(&b.outboxSeq, 1)})
+	if err != nil {
+		return false, err
+	}
+	if err := b.Memory.Put(b, "outbox:"+out.Id, string(data)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// doneOutbox removes the message from the outbox, and remembers it was sent.
+func (b *BotKit) doneOutbox(out *OutgoingMessage) {
+	b.dropOutbox(out)
+	if err := b.Memory.Put(b, "outbox_sent:"+out.Id, strconv.FormatInt(model.GetMillis(), 10)); err != nil {
+		errorf("We failed to mark the message '%s' as sent: %v\n", out.Id, err.Error())
+	}
+}
+
+// dropOutbox removes the message given up from the outbox, so that it can be sent again.
+func (b *BotKit) dropOutbox(out *OutgoingMessage) {
+	if _, err := b.Memory.Del(b, "outbox:"+out.Id); err != nil {
+		errorf("We failed to remove the message '%s' from the outbox: %v\n", out.Id, err.Error())
+	}
+}
+
+// resendOutbox queues the messages left in the outbox, unless they were posted before the bot stopped.
+func (b *BotKit) resendOutbox() {
+	list, err := b.Memory.List(b)
+	if err != nil {
+		errorf("We failed to read the outbox: %v\n", err.Error())
+		return
+	}
+
+	pending := []*outboxEntry{}
+	for key, val := range list {
+		if !strings.HasPrefix(key, "outbox:") {
+			continue
+		}
+
+		entry := &outboxEntry{OutgoingMessage: &OutgoingMessage{}}
+		if err := json.Unmarshal([]byte(val), entry); err != nil {
+			errorf("We failed to read the message '%s' in the outbox: %v\n", key, err.Error())
+			continue
+		}
+		pending = append(pending, entry)
+	}
+	b.pruneOutbox()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	// the sequence restarts with the bot, but the messages of a run have a later QueuedAt
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].QueuedAt != pending[j].QueuedAt {
+			return pending[i].QueuedAt < pending[j].QueuedAt
+		}
+		return pending[i].OutboxSeq < pending[j].OutboxSeq
+	})
+
+	infof("Resend %d messages left in the outbox\n", len(pending))
+	posted := map[string]map[string]bool{}
+	for _, entry := range pending {
+		out := entry.OutgoingMessage
+		if _, ok := posted[out.ChannelId]; !ok {
+			posted[out.ChannelId] = b.postedOutboxIds(out.ChannelId, out.QueuedAt)
+		}
+
+		// the bot may have stopped after sending the message, before marking it
+		if posted[out.ChannelId][out.Id] {
+			b.doneOutbox(out)
+			continue
+		}
+
+		if err := b.push(out, func(*PostHandle, error, int) {}); err != nil {
+			errorf("We failed to resend the message '%s': %v\n", out.Id, err.Error())
+		}
+	}
+}
+
+// pruneOutbox forgets the ids of the messages sent longer ago than OUTBOX_SENT_TTL.
+func (b *BotKit) pruneOutbox() {
+	list, err := b.Memory.List(b)
+	if err != nil {
+		errorf("We failed to read the outbox: %v\n", err.Error())
+		return
+	}
+
+	for key, val := range list {
+		if strings.HasPrefix(key, "outbox_sent:") && sentExpired(val) {
+			b.Memory.Del(b, key)
+		}
+	}
+}
+
+// sentExpired returns true if the message sent at the time in milliseconds is not to be deduplicated anymore.
+func sentExpired(sentAt string) bool {
+	at, _ := strconv.ParseInt(sentAt, 10, 64)
+	return at < model.GetMillis()-int64(OUTBOX_SENT_TTL/time.Millisecond)
+}
+
+// postedOutboxIds returns the outbox ids of the posts in the channel since the time.
+func (b *BotKit) postedOutboxIds(channelId string, since int64) map[string]bool {
+	ids := map[string]bool{}
+	for id := range b.postedOutboxPosts(channelId, since) {
+		ids[id] = true
+	}
+	return ids
+}
+
+// postedOutboxPosts returns the posts in the channel since the time by their outbox ids.
+func (b *BotKit) postedOutboxPosts(channelId string, since int64) map[string]*model.Post {
+	found := map[string]*model.Post{}
+
+	posts, err := b.adapter.GetPostsSince(channelId, since-1)
+	if err != nil {
+		errorf("We failed to get the posts since %d in the channel '%s': %v\n", since, channelId, err.Error())
+		return found
+	}
+
+	for _, post := range posts {
+		if id, ok := post.Props[OUTBOX_ID_PROP].(string); ok {
+			found[id] = post
+		}
+	}
+	return found
+}
+
+// outboxIds returns the ids of the parts of a message, based on the id set by the plugin if any.
+func outboxIds(id string, parts int) []string {
+	if id == "" {
+		id = model.NewId()
+	}
+	if parts == 1 {
+		return []string{id}
+	}
+
+	ids := make([]string, parts)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%s:%d", id, i+1)
+	}
+	return ids
+}
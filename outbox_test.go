@@ -0,0 +1,178 @@
+package mmbot
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mattermost/platform/model"
+)
+
+func TestSendDeduplicates(t *testing.T) {
+	b, adapter := newTestBot(t)
+
+	if _, err := b.SendAndWait(b.ctx, &OutgoingMessage{Id: "greeting", Text: "hello", Channel: "town-square"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same id is skipped, and its delivery ends with ErrDuplicate
+	if _, err := b.SendAndWait(b.ctx, &OutgoingMessage{Id: "greeting", Text: "hello", Channel: "town-square"}); err != ErrDuplicate {
+		t.Fatalf("expected the message to be skipped, got %v", err)
+	}
+	if posts := adapter.Posts(); len(posts) != 1 {
+		t.Fatalf("expected a single post, got %d", len(posts))
+	}
+	if id := adapter.Posts()[0].Props[OUTBOX_ID_PROP]; id != "greeting" {
+		t.Fatalf("expected the post to be marked with its outbox id, got %v", id)
+	}
+}
+
+func TestSendDeduplicatesConcurrent(t *testing.T) {
+	b, adapter := newTestBot(t)
+
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			_, err := b.SendAndWait(b.ctx, &OutgoingMessage{Id: "greeting", Text: "hello", Channel: "town-square"})
+			errs <- err
+		}()
+	}
+
+	duplicates := 0
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err == ErrDuplicate {
+			duplicates++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if duplicates != 9 || len(adapter.Posts()) != 1 {
+		t.Fatalf("expected a single post and 9 duplicates, got %d posts and %d duplicates", len(adapter.Posts()), duplicates)
+	}
+}
+
+func TestSendAgainAfterGivingUp(t *testing.T) {
+	b, adapter := newTestBot(t)
+	config := *b.Config()
+	config.Queue.MaxRetries = 0
+	b.configMu.Lock()
+	b.config = &config
+	b.configMu.Unlock()
+	adapter.failures = 1
+
+	if _, err := b.SendAndWait(b.ctx, &OutgoingMessage{Id: "greeting", Text: "hello", Channel: "town-square"}); err == nil || err == ErrDuplicate {
+		t.Fatalf("expected the message to be given up, got %v", err)
+	}
+	if _, err := b.Memory.Get(b, "outbox_sent:greeting"); err == nil {
+		t.Fatal("expected the message given up not to be marked as sent")
+	}
+
+	// the message given up is not a duplicate
+	if _, err := b.SendAndWait(b.ctx, &OutgoingMessage{Id: "greeting", Text: "hello", Channel: "town-square"}); err != nil {
+		t.Fatal(err)
+	}
+	if posts := adapter.Posts(); len(posts) != 1 {
+		t.Fatalf("expected a single post, got %d", len(posts))
+	}
+}
+
+func TestSaveOutboxExpired(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	sentAt := model.GetMillis() - int64(2*OUTBOX_SENT_TTL/time.Millisecond)
+	b.Memory.Put(b, "outbox_sent:old", strconv.FormatInt(sentAt, 10))
+	b.Memory.Put(b, "outbox_sent:recent", strconv.FormatInt(model.GetMillis(), 10))
+
+	if fresh, err := b.saveOutbox(&OutgoingMessage{Id: "old"}); err != nil || !fresh {
+		t.Fatalf("expected the expired id to be sent again, got %v, %v", fresh, err)
+	}
+	if fresh, err := b.saveOutbox(&OutgoingMessage{Id: "recent"}); err != nil || fresh {
+		t.Fatalf("expected the recent id to be skipped, got %v, %v", fresh, err)
+	}
+}
+
+func TestPruneOutbox(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	sentAt := model.GetMillis() - int64(2*OUTBOX_SENT_TTL/time.Millisecond)
+	b.Memory.Put(b, "outbox_sent:old", strconv.FormatInt(sentAt, 10))
+	b.Memory.Put(b, "outbox_sent:recent", strconv.FormatInt(model.GetMillis(), 10))
+
+	b.pruneOutbox()
+	if _, err := b.Memory.Get(b, "outbox_sent:old"); err == nil {
+		t.Fatal("expected the expired id to be removed")
+	}
+	if _, err := b.Memory.Get(b, "outbox_sent:recent"); err != nil {
+		t.Fatal("expected the recent id to be kept")
+	}
+}
+
+func TestResendOutbox(t *testing.T) {
+	b, adapter := newTestBot(t)
+	channel, _ := b.getChannelByName("town-square")
+
+	// the bot stopped with two messages in the outbox, after posting the first one
+	posted := &OutgoingMessage{Id: "posted", Text: "posted", Channel: channel.Name, ChannelId: channel.Id, QueuedAt: model.GetMillis()}
+	pending := &OutgoingMessage{Id: "pending", Text: "pending", Channel: channel.Name, ChannelId: channel.Id, QueuedAt: model.GetMillis()}
+	for _, out := range []*OutgoingMessage{posted, pending} {
+		if fresh, err := b.saveOutbox(out); err != nil || !fresh {
+			t.Fatalf("failed to save %s: %v", out.Id, err)
+		}
+	}
+	if _, err := adapter.CreatePost(&model.Post{ChannelId: channel.Id, Message: posted.Text, Props: posted.props()}); err != nil {
+		t.Fatal(err)
+	}
+
+	b.resendOutbox()
+	waitFor(t, "the pending message", func() bool { return len(adapter.Posts()) == 2 })
+	if text := adapter.Posts()[1].Message; text != "pending" {
+		t.Fatalf("expected the pending message to be resent, got %q", text)
+	}
+
+	// both are marked as sent
+	waitFor(t, "the pending message to be marked", func() bool {
+		_, err := b.Memory.Get(b, "outbox_sent:pending")
+		return err == nil
+	})
+	for _, id := range []string{"posted", "pending"} {
+		if _, err := b.Memory.Get(b, "outbox:"+id); err == nil {
+			t.Fatalf("expected %s to be removed from the outbox", id)
+		}
+		if _, err := b.Memory.Get(b, "outbox_sent:"+id); err != nil {
+			t.Fatalf("expected %s to be marked as sent", id)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := len(adapter.Posts()); n != 2 {
+		t.Fatalf("expected no more posts, got %d", n)
+	}
+}
+
+func TestResendOutboxInOrder(t *testing.T) {
+	b, adapter := newTestBot(t)
+	channel, _ := b.getChannelByName("town-square")
+
+	// the parts of split messages share their QueuedAt, and their ids do not sort in order
+	queuedAt := model.GetMillis()
+	expected := []string{}
+	for i := 0; i < 5; i++ {
+		for _, part := range []string{"d", "c", "b", "a"} {
+			id := fmt.Sprintf("m%d:%s", i, part)
+			out := &OutgoingMessage{Id: id, Text: id, Channel: channel.Name, ChannelId: channel.Id, QueuedAt: queuedAt}
+			if _, err := b.saveOutbox(out); err != nil {
+				t.Fatal(err)
+			}
+			expected = append(expected, id)
+		}
+	}
+
+	b.resendOutbox()
+	waitFor(t, "the resent messages", func() bool { return len(adapter.Posts()) == len(expected) })
+	for i, post := range adapter.Posts() {
+		if post.Message != expected[i] {
+			t.Fatalf("expected %s at %d, got %s", expected[i], i, post.Message)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package mmbot
+
+import (
+	"sync"
+)
+
+// PluginBase keeps the settings shared by plugins up to date, and sends messages with them.
+// Embed it in a plugin to implement Init and Reconfigure:
+//
+//	p := &Plugin{Router: mmbot.NewRouter()}
+//	p.PluginBase = mmbot.NewPluginBase(bot, p, mmbot.PluginConfig{Username: "Echo"})
+type PluginBase struct {
+	bot      *BotKit
+	name     string
+	defaults PluginConfig
+
+	mu       sync.RWMutex
+	settings PluginConfig
+}
+
+// NewPluginBase returns the base of the plugin, which uses the defaults until the configuration is applied.
+func NewPluginBase(bot *BotKit, plugin interface{}, defaults PluginConfig) *PluginBase {
+	return &PluginBase{bot: bot, name: PluginName(plugin), defaults: defaults, settings: defaults}
+}
+
+// Init applies the plugin section of the configuration when the bot starts.
+func (pb *PluginBase) Init() error {
+	return pb.Reconfigure(pb.bot.Config())
+}
+
+// Reconfigure applies the username and the icon in the plugin section of the configuration.
+func (pb *PluginBase) Reconfigure(config *Config) error {
+	settings := pb.defaults
+	if err := config.Plugin(pb.name, &settings); err != nil {
+		return err
+	}
+
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	pb.settings = settings
+	return nil
+}
+
+// Settings returns the username and the icon the plugin sends messages with.
+func (pb *PluginBase) Settings() PluginConfig {
+	pb.mu.RLock()
+	defer pb.mu.RUnlock()
+	return pb.settings
+}
+
+// Reply answers the message with the username and the icon of the plugin.
+func (pb *PluginBase) Reply(msg *Message, text string) error {
+	settings := pb.Settings()
+	return pb.bot.Reply(msg, text, settings.Username, settings.IconUrl)
+}
+
+// Send posts the text in the channel with the username and the icon of the plugin.
+func (pb *PluginBase) Send(text, channel string) error {
+	settings := pb.Settings()
+	return pb.bot.SendMessage(text, channel, settings.Username, settings.IconUrl)
+}
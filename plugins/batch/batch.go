@@ -1,72 +1,98 @@
 package batch
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"mattermost-bot"
 )
 
 type Plugin struct {
-	bot      *mmbot.BotKit
-	username string
-	icon_url string
+	*mmbot.Router
+	*mmbot.PluginBase
+	bot    *mmbot.BotKit
+	mu     sync.Mutex
+	timers []*time.Timer
+	// guards the location
+	settingsMu sync.RWMutex
+	location   *time.Location
+}
+
+// Config is the plugin section of the configuration, besides the username and the icon.
+type Config struct {
+	// time zone of the time specs, such as "Asia/Tokyo" or "Local"
+	Location string `yaml:"location"`
 }
 
 func NewPlugin(bot *mmbot.BotKit) *Plugin {
-	p := &Plugin{bot: bot, username: "Batch scheduler"}
-	p.refreshBatchTasks()
+	p := &Plugin{Router: mmbot.NewRouter(), bot: bot}
+	p.PluginBase = mmbot.NewPluginBase(bot, p, mmbot.PluginConfig{Username: "Batch scheduler"})
+
+	p.Command("batch add <spec:backtick> <task:rest>", "Add a batch task.", p.add)
+	p.Command("batch del <id>", "Delete the batch task.", p.del)
+	p.Command("batch list", "List all batch tasks.", p.list)
 	return p
 }
 
-func (p *Plugin) HandleMessage(text, channel, username string) error {
-	var re *regexp.Regexp
+// Init applies the plugin section of the configuration when the bot starts.
+func (p *Plugin) Init() error {
+	return p.Reconfigure(p.bot.Config())
+}
+
+// Reconfigure applies the plugin section of the configuration.
+// The batch tasks are rescheduled when the location changes.
+func (p *Plugin) Reconfigure(config *mmbot.Config) error {
+	settings := Config{Location: "Asia/Tokyo"}
+	if err := config.Plugin(mmbot.PluginName(p), &settings); err != nil {
+		return err
+	}
 
-	// add batch task
-	re = regexp.MustCompile(`(?i)^batch\s+add\s+(` + "`" + `.+` + "`" + `.+)$`)
-	if re.MatchString(text) {
-		submatch := re.FindSubmatch([]byte(text))
-		p.addBatchTask(channel, string(submatch[1]))
-		return nil
+	loc, err := time.LoadLocation(settings.Location)
+	if err != nil {
+		return fmt.Errorf("Invalid location '%s' of the batch plugin: %v", settings.Location, err.Error())
 	}
 
-	// delete batch task
-	re = regexp.MustCompile(`(?i)^batch\s+del\s+(.*)$`)
-	if re.MatchString(text) {
-		submatch := re.FindSubmatch([]byte(text))
-		p.delBatchTask(channel, string(submatch[1]))
-		return nil
+	if err := p.PluginBase.Reconfigure(config); err != nil {
+		return err
 	}
 
-	// list batch tasks
-	re = regexp.MustCompile(`(?i)^batch\s+list$`)
-	if re.MatchString(text) {
-		p.listBatchTasks(channel)
-		return nil
+	p.settingsMu.Lock()
+	moved := p.location != nil && p.location.String() != loc.String()
+	p.location = loc
+	p.settingsMu.Unlock()
+
+	if moved {
+		return p.refreshBatchTasks()
 	}
+	return nil
+}
 
+func (p *Plugin) add(msg *mmbot.Message, args mmbot.Args) error {
+	p.addBatchTask(msg, fmt.Sprintf("`%s` %s", args.String("spec"), args.String("task")))
 	return nil
 }
 
-func (p *Plugin) Usage() string {
-	usages := []string{
-		`batch add ` + "`" + `<spec>` + "`" + ` <task>: Add a batch task.`,
-		`batch del <id>: Delete the batch task.`,
-		`batch list: List all batch tasks.`,
-	}
-	return strings.Join(usages, "\n")
+func (p *Plugin) del(msg *mmbot.Message, args mmbot.Args) error {
+	p.delBatchTask(msg, args.String("id"))
+	return nil
+}
+
+func (p *Plugin) list(msg *mmbot.Message, args mmbot.Args) error {
+	return p.listBatchTasks(msg)
 }
 
-func (p *Plugin) addBatchTask(channel, batchTask string) {
+func (p *Plugin) addBatchTask(msg *mmbot.Message, batchTask string) {
 	// generate uniq batchId
 	var batchId, batchKey string
 	for {
 		rand.Seed(time.Now().UnixNano())
 		batchId = fmt.Sprint(rand.Intn(1000))
-		batchKey = fmt.Sprintf("%s:%s", channel, batchId)
+		batchKey = fmt.Sprintf("%s:%s", msg.Channel, batchId)
 		if _, err := p.bot.Memory.Get(p, batchKey); err != nil {
 			break
 		}
@@ -74,42 +100,42 @@ func (p *Plugin) addBatchTask(channel, batchTask string) {
 
 	if err := p.bot.Memory.Put(p, batchKey, batchTask); err != nil {
 		message := fmt.Sprintf("Invalid batch '%s'\n%s", batchId, err.Error())
-		p.bot.SendMessage(message, channel, p.username, p.icon_url)
+		p.Reply(msg, message)
 	} else {
 		if err := p.refreshBatchTasks(); err != nil {
-			p.delBatchTask(channel, batchId)
+			p.delBatchTask(msg, batchId)
 			message := fmt.Sprintf("Failed to restart scheduler '%s'\n%s", batchTask, err.Error())
-			p.bot.SendMessage(message, channel, p.username, p.icon_url)
+			p.Reply(msg, message)
 		} else {
 			message := "Added batch.\n"
 			message += "```\n"
 			message += fmt.Sprintf("%s: %s\n", batchId, batchTask)
 			message += "```"
-			p.bot.SendMessage(message, channel, p.username, p.icon_url)
+			p.Reply(msg, message)
 		}
 	}
 }
 
-func (p *Plugin) delBatchTask(channel, batchId string) {
-	batchKey := fmt.Sprintf("%s:%s", channel, batchId)
+func (p *Plugin) delBatchTask(msg *mmbot.Message, batchId string) {
+	batchKey := fmt.Sprintf("%s:%s", msg.Channel, batchId)
 	if batchTask, err := p.bot.Memory.Del(p, batchKey); err != nil {
 		message := fmt.Sprintf("Invalid batch '%s'\n%s", batchId, err.Error())
-		p.bot.SendMessage(message, channel, p.username, p.icon_url)
+		p.Reply(msg, message)
 	} else {
 		if err := p.refreshBatchTasks(); err != nil {
 			message := fmt.Sprintf("Failed to restart scheduler '%s'\n%s", batchTask, err.Error())
-			p.bot.SendMessage(message, channel, p.username, p.icon_url)
+			p.Reply(msg, message)
 		} else {
 			message := "Deleted batch.\n"
 			message += "```\n"
 			message += fmt.Sprintf("%s: %s\n", batchId, batchTask)
 			message += "```"
-			p.bot.SendMessage(message, channel, p.username, p.icon_url)
+			p.Reply(msg, message)
 		}
 	}
 }
 
-func (p *Plugin) listBatchTasks(channel string) {
+func (p *Plugin) listBatchTasks(msg *mmbot.Message) error {
 	re := regexp.MustCompile(`^` + "`" + `\s*([^` + "`" + `]+)\s*` + "`" + `\s+(.+)$`)
 	batchList := map[string]string{}
 
@@ -122,7 +148,7 @@ func (p *Plugin) listBatchTasks(channel string) {
 			t2, _ := p.parseTimeSpec(string(submatch[1]))
 
 			if t2.After(t1) {
-				if strings.HasPrefix(batchKey, channel+":") {
+				if strings.HasPrefix(batchKey, msg.Channel+":") {
 					batchList[batchKey] = batchTask
 				}
 			} else {
@@ -134,15 +160,15 @@ func (p *Plugin) listBatchTasks(channel string) {
 
 	if len(batchList) == 0 {
 		message := "Could not find batchs."
-		p.bot.SendMessage(message, channel, p.username, p.icon_url)
+		return p.Reply(msg, message)
 	} else {
 		message := "```\n"
 		for batchKey, batchTask := range batchList {
-			batchId := batchKey[len(channel)+1:]
+			batchId := batchKey[len(msg.Channel)+1:]
 			message += fmt.Sprintf("%s: %s\n", batchId, batchTask)
 		}
 		message += "```"
-		p.bot.SendMessage(message, channel, p.username, p.icon_url)
+		return p.Reply(msg, message)
 	}
 }
 
@@ -152,6 +178,11 @@ func (p *Plugin) refreshBatchTasks() error {
 	batchList := map[string]string{}
 	batchList, _ = p.bot.Memory.List(p)
 
+	// renew timers
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopTimers()
+
 	for batchKey, batchTask := range batchList {
 		submatch := re.FindSubmatch([]byte(batchTask))
 
@@ -163,23 +194,43 @@ func (p *Plugin) refreshBatchTasks() error {
 		}
 
 		if t2.After(t1) {
-			go func() {
-				channel := batchKey[:strings.Index(batchKey, ":")]
+			channel := batchKey[:strings.Index(batchKey, ":")]
+			text := string(submatch[2])
 
-				select {
-				case <-time.After(t2.Sub(t1)):
-					p.bot.SendMessage(string(submatch[2]), channel, p.username, p.icon_url)
+			p.timers = append(p.timers, time.AfterFunc(t2.Sub(t1), func() {
+				if err := p.Send(text, channel); err != nil {
+					mmbot.Logf(mmbot.LOG_ERROR, "We failed to send the batch task '%s': %v\n", text, err.Error())
 				}
-			}()
+			}))
 		}
 	}
 
 	return nil
 }
 
+func (p *Plugin) Start(ctx context.Context) error {
+	return p.refreshBatchTasks()
+}
+
+func (p *Plugin) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopTimers()
+	return nil
+}
+
+func (p *Plugin) stopTimers() {
+	for _, timer := range p.timers {
+		timer.Stop()
+	}
+	p.timers = nil
+}
+
 func (p *Plugin) parseTimeSpec(spec string) (time.Time, error) {
-	now := time.Now()
-	loc, _ := time.LoadLocation("Asia/Tokyo")
+	p.settingsMu.RLock()
+	loc := p.location
+	p.settingsMu.RUnlock()
+	now := time.Now().In(loc)
 
 	var err error
 	var parsedTime time.Time
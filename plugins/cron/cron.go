@@ -1,10 +1,12 @@
 package cron
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/robfig/cron"
@@ -12,63 +14,44 @@ import (
 )
 
 type Plugin struct {
-	bot      *mmbot.BotKit
-	cron     *cron.Cron
-	username string
-	icon_url string
+	*mmbot.Router
+	*mmbot.PluginBase
+	bot  *mmbot.BotKit
+	mu   sync.Mutex
+	cron *cron.Cron
 }
 
 func NewPlugin(bot *mmbot.BotKit) *Plugin {
-	p := &Plugin{bot: bot, username: "Cron"}
-	p.restartCronTasks()
+	p := &Plugin{Router: mmbot.NewRouter(), bot: bot}
+	p.PluginBase = mmbot.NewPluginBase(bot, p, mmbot.PluginConfig{Username: "Cron"})
+
+	p.Command("cron add <spec:backtick> <task:rest>", "Add a cron task.", p.add)
+	p.Command("cron del <id>", "Delete the cron task.", p.del)
+	p.Command("cron list", "List all cron tasks.", p.list)
 	return p
 }
 
-func (p *Plugin) HandleMessage(text, channel, username string) error {
-	var re *regexp.Regexp
-
-	// add cron task
-	re = regexp.MustCompile(`(?i)^cron\s+add\s+(` + "`" + `.+` + "`" + `.+)$`)
-	if re.MatchString(text) {
-		submatch := re.FindSubmatch([]byte(text))
-		p.addCronTask(channel, string(submatch[1]))
-		return nil
-	}
-
-	// delete cron task
-	re = regexp.MustCompile(`(?i)^cron\s+del\s+(.*)$`)
-	if re.MatchString(text) {
-		submatch := re.FindSubmatch([]byte(text))
-		p.delCronTask(channel, string(submatch[1]))
-		return nil
-	}
-
-	// list cron tasks
-	re = regexp.MustCompile(`(?i)^cron\s+list$`)
-	if re.MatchString(text) {
-		p.listCronTasks(channel)
-		return nil
-	}
+func (p *Plugin) add(msg *mmbot.Message, args mmbot.Args) error {
+	p.addCronTask(msg, fmt.Sprintf("`%s` %s", args.String("spec"), args.String("task")))
+	return nil
+}
 
+func (p *Plugin) del(msg *mmbot.Message, args mmbot.Args) error {
+	p.delCronTask(msg, args.String("id"))
 	return nil
 }
 
-func (p *Plugin) Usage() string {
-	usages := []string{
-		`cron add ` + "`" + `<spec>` + "`" + ` <task>: Add a cron task.`,
-		`cron del <id>: Delete the cron task.`,
-		`cron list: List all cron tasks.`,
-	}
-	return strings.Join(usages, "\n")
+func (p *Plugin) list(msg *mmbot.Message, args mmbot.Args) error {
+	return p.listCronTasks(msg)
 }
 
-func (p *Plugin) addCronTask(channel, cronTask string) {
+func (p *Plugin) addCronTask(msg *mmbot.Message, cronTask string) {
 	// generate uniq cronId and cronKey
 	var cronId, cronKey string
 	for {
 		rand.Seed(time.Now().UnixNano())
 		cronId = fmt.Sprint(rand.Intn(1000))
-		cronKey = fmt.Sprintf("%s:%s", channel, cronId)
+		cronKey = fmt.Sprintf("%s:%s", msg.Channel, cronId)
 		if _, err := p.bot.Memory.Get(p, cronKey); err != nil {
 			break
 		}
@@ -76,48 +59,48 @@ func (p *Plugin) addCronTask(channel, cronTask string) {
 
 	if err := p.bot.Memory.Put(p, cronKey, cronTask); err != nil {
 		message := fmt.Sprintf("Invalid cron task '%s'\n%s", cronId, err.Error())
-		p.bot.SendMessage(message, channel, p.username, p.icon_url)
+		p.Reply(msg, message)
 	} else {
 		if err := p.restartCronTasks(); err != nil {
-			p.delCronTask(channel, cronId)
+			p.delCronTask(msg, cronId)
 			message := fmt.Sprintf("Failed to restart cron '%s'\n%s", cronTask, err.Error())
-			p.bot.SendMessage(message, channel, p.username, p.icon_url)
+			p.Reply(msg, message)
 		} else {
 			message := "Added cron task.\n"
 			message += "```\n"
 			message += fmt.Sprintf("%s: %s\n", cronId, cronTask)
 			message += "```"
-			p.bot.SendMessage(message, channel, p.username, p.icon_url)
+			p.Reply(msg, message)
 		}
 	}
 }
 
-func (p *Plugin) delCronTask(channel, cronId string) {
-	cronKey := fmt.Sprintf("%s:%s", channel, cronId)
+func (p *Plugin) delCronTask(msg *mmbot.Message, cronId string) {
+	cronKey := fmt.Sprintf("%s:%s", msg.Channel, cronId)
 	if cronTask, err := p.bot.Memory.Del(p, cronKey); err != nil {
 		message := fmt.Sprintf("Invalid cron task '%s'\n%s", cronId, err.Error())
-		p.bot.SendMessage(message, channel, p.username, p.icon_url)
+		p.Reply(msg, message)
 	} else {
 		if err := p.restartCronTasks(); err != nil {
 			message := fmt.Sprintf("Failed to restart cron '%s'\n%s", cronId, err.Error())
-			p.bot.SendMessage(message, channel, p.username, p.icon_url)
+			p.Reply(msg, message)
 		} else {
 			message := "Deleted cron task.\n"
 			message += "```\n"
 			message += fmt.Sprintf("%s: %s\n", cronId, cronTask)
 			message += "```"
-			p.bot.SendMessage(message, channel, p.username, p.icon_url)
+			p.Reply(msg, message)
 		}
 	}
 }
 
-func (p *Plugin) listCronTasks(channel string) {
+func (p *Plugin) listCronTasks(msg *mmbot.Message) error {
 	cronList := map[string]string{}
 
 	// get tasks in the specified channel
 	if list, err := p.bot.Memory.List(p); err == nil {
 		for cronKey, cronTask := range list {
-			if strings.HasPrefix(cronKey, channel+":") {
+			if strings.HasPrefix(cronKey, msg.Channel+":") {
 				cronList[cronKey] = cronTask
 			}
 		}
@@ -125,30 +108,32 @@ func (p *Plugin) listCronTasks(channel string) {
 
 	if len(cronList) == 0 {
 		message := "Could not find cron tasks."
-		p.bot.SendMessage(message, channel, p.username, p.icon_url)
+		return p.Reply(msg, message)
 	} else {
 		message := "```\n"
 		for cronKey, cronTask := range cronList {
-			cronId := cronKey[len(channel)+1:]
+			cronId := cronKey[len(msg.Channel)+1:]
 			message += fmt.Sprintf("%s: %s\n", cronId, cronTask)
 		}
 		message += "```"
-		p.bot.SendMessage(message, channel, p.username, p.icon_url)
+		return p.Reply(msg, message)
 	}
 }
 
 func (p *Plugin) restartCronTasks() error {
+	// get all tasks
+	re := regexp.MustCompile(`^` + "`" + `\s*([^` + "`" + `]+)\s*` + "`" + `\s+(.+)$`)
+	cronList := map[string]string{}
+	cronList, _ = p.bot.Memory.List(p)
+
 	// renew cron client
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if p.cron != nil {
 		p.cron.Stop()
 	}
 	p.cron = cron.New()
 
-	// get all tasks
-	re := regexp.MustCompile(`^` + "`" + `\s*([^` + "`" + `]+)\s*` + "`" + `\s+(.+)$`)
-	cronList := map[string]string{}
-	cronList, _ = p.bot.Memory.List(p)
-
 	// add tasks to the cron client
 	for cronKey, cronTask := range cronList {
 		channel := cronKey[:strings.Index(cronKey, ":")]
@@ -156,7 +141,9 @@ func (p *Plugin) restartCronTasks() error {
 		submatch := re.FindSubmatch([]byte(cronTask))
 
 		p.cron.AddFunc(string(submatch[1]), func() {
-			p.bot.SendMessage(string(submatch[2]), channel, p.username, p.icon_url)
+			if err := p.Send(string(submatch[2]), channel); err != nil {
+				mmbot.Logf(mmbot.LOG_ERROR, "We failed to send the cron task '%s': %v\n", submatch[2], err.Error())
+			}
 		})
 	}
 
@@ -164,3 +151,26 @@ func (p *Plugin) restartCronTasks() error {
 	p.cron.Start()
 	return nil
 }
+
+func (p *Plugin) Start(ctx context.Context) error {
+	return p.restartCronTasks()
+}
+
+func (p *Plugin) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cron != nil {
+		p.cron.Stop()
+		p.cron = nil
+	}
+	return nil
+}
+
+func (p *Plugin) Health() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cron == nil {
+		return fmt.Errorf("Cron scheduler is not running")
+	}
+	return nil
+}
@@ -1,32 +1,23 @@
 package echo
 
 import (
-	"regexp"
-
 	"mattermost-bot"
 )
 
 type Plugin struct {
-	bot      *mmbot.BotKit
-	username string
-	icon_url string
+	*mmbot.Router
+	*mmbot.PluginBase
+	bot *mmbot.BotKit
 }
 
 func NewPlugin(bot *mmbot.BotKit) *Plugin {
-	return &Plugin{bot: bot, username: "Echo"}
-}
-
-func (p *Plugin) HandleMessage(text, channel, username string) error {
-	re := regexp.MustCompile(`(?i)^echo\s+(.*)$`)
-	if re.MatchString(text) {
-		bytes := []byte(text)
-		group := re.FindSubmatch(bytes)
-		p.bot.SendMessage(string(group[1]), channel, p.username, p.icon_url)
-	}
+	p := &Plugin{Router: mmbot.NewRouter(), bot: bot}
+	p.PluginBase = mmbot.NewPluginBase(bot, p, mmbot.PluginConfig{Username: "Echo"})
 
-	return nil
+	p.Command("echo <text:rest>", "Echo your message.", p.echo)
+	return p
 }
 
-func (p *Plugin) Usage() string {
-	return `echo: Echo your message.`
+func (p *Plugin) echo(msg *mmbot.Message, args mmbot.Args) error {
+	return p.Reply(msg, args.String("text"))
 }
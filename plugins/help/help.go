@@ -2,31 +2,40 @@ package help
 
 import (
 	"fmt"
-	"regexp"
+	"strings"
 
 	"mattermost-bot"
 )
 
 type Plugin struct {
-	bot      *mmbot.BotKit
-	username string
-	icon_url string
+	*mmbot.Router
+	*mmbot.PluginBase
+	bot *mmbot.BotKit
 }
 
 func NewPlugin(bot *mmbot.BotKit) *Plugin {
-	return &Plugin{bot: bot, username: "Help"}
+	p := &Plugin{Router: mmbot.NewRouter(), bot: bot}
+	p.PluginBase = mmbot.NewPluginBase(bot, p, mmbot.PluginConfig{Username: "Help"})
+
+	p.Command("help [<command:rest>]", "Display this message, or the commands starting with <command>.", p.help)
+	return p
 }
 
-func (p *Plugin) HandleMessage(text, channel, username string) error {
-	re := regexp.MustCompile(`(?i)^help$`)
-	if re.MatchString(text) {
-		message := fmt.Sprintf("What can I do for you?\n```\n%s```", p.bot.Usage())
-		p.bot.SendMessage(message, channel, p.username, p.icon_url)
+func (p *Plugin) help(msg *mmbot.Message, args mmbot.Args) error {
+	command := strings.ToLower(args.String("command"))
+
+	usages := []string{}
+	for _, entry := range p.bot.Help() {
+		if strings.HasPrefix(strings.ToLower(entry.Usage), command) {
+			usages = append(usages, entry.String())
+		}
 	}
 
-	return nil
-}
+	if len(usages) == 0 {
+		message := fmt.Sprintf("Could not find the command '%s'.", command)
+		return p.Reply(msg, message)
+	}
 
-func (p *Plugin) Usage() string {
-	return `help: Display this message.`
+	message := fmt.Sprintf("What can I do for you?\n```\n%s\n```", strings.Join(usages, "\n"))
+	return p.Reply(msg, message)
 }
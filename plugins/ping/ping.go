@@ -1,30 +1,23 @@
 package ping
 
 import (
-	"regexp"
-
 	"mattermost-bot"
 )
 
 type Plugin struct {
-	bot      *mmbot.BotKit
-	username string
-	icon_url string
+	*mmbot.Router
+	*mmbot.PluginBase
+	bot *mmbot.BotKit
 }
 
 func NewPlugin(bot *mmbot.BotKit) *Plugin {
-	return &Plugin{bot: bot, username: "Ping"}
-}
-
-func (p *Plugin) HandleMessage(text, channel, username string) error {
-	re := regexp.MustCompile(`(?i)^ping$`)
-	if re.MatchString(text) {
-		p.bot.SendMessage("PONG", channel, p.username, p.icon_url)
-	}
+	p := &Plugin{Router: mmbot.NewRouter(), bot: bot}
+	p.PluginBase = mmbot.NewPluginBase(bot, p, mmbot.PluginConfig{Username: "Ping"})
 
-	return nil
+	p.Command("ping", "See if the bot is alive.", p.ping)
+	return p
 }
 
-func (p *Plugin) Usage() string {
-	return `ping: See if the bot is alive.`
+func (p *Plugin) ping(msg *mmbot.Message, args mmbot.Args) error {
+	return p.Reply(msg, "PONG")
 }
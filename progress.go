@@ -0,0 +1,128 @@
+package mmbot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	PROGRESS_INTERVAL = 2 * time.Second
+)
+
+// Progress is a status message edited in place while a plugin works, such as "Working..." turning into the result.
+// Updates are throttled to one per interval, and only the latest text of an interval is posted.
+type Progress struct {
+	bot      *BotKit
+	handle   *PostHandle
+	interval time.Duration
+
+	mu      sync.Mutex
+	text    string
+	posted  string
+	last    time.Time
+	timer   *time.Timer
+	stopped bool
+
+	// serializes the requests, so that a late update cannot overwrite the final text
+	sendMu sync.Mutex
+}
+
+// StartProgress replies to the message with the text, and returns the progress editing the reply.
+func (b *BotKit) StartProgress(msg *Message, text string) (*Progress, error) {
+	out := NewReply(msg, text)
+	out.UseAPI = true
+
+	handle, err := b.SendAndWait(msg.Context(), out)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Progress{
+		bot:      b,
+		handle:   handle,
+		interval: PROGRESS_INTERVAL,
+		text:     text,
+		posted:   text,
+		last:     time.Now(),
+	}, nil
+}
+
+// Post returns the handle of the status message.
+func (p *Progress) Post() *PostHandle {
+	return p.handle
+}
+
+// Update changes the status message. It does not wait for the post to be edited.
+func (p *Progress) Update(text string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return
+	}
+	p.text = text
+
+	if p.timer == nil {
+		wait := p.interval - time.Since(p.last)
+		if wait < 0 {
+			wait = 0
+		}
+		p.timer = time.AfterFunc(wait, p.flush)
+	}
+}
+
+func (p *Progress) Updatef(format string, a ...interface{}) {
+	p.Update(fmt.Sprintf(format, a...))
+}
+
+// flush posts the latest text of the interval.
+func (p *Progress) flush() {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+
+	p.mu.Lock()
+	text, stopped := p.text, p.stopped
+	changed := text != p.posted
+	p.timer = nil
+	p.last = time.Now()
+	p.posted = text
+	p.mu.Unlock()
+
+	if stopped || !changed {
+		return
+	}
+
+	if err := p.bot.UpdatePost(p.handle, text); err != nil {
+		errorf("%v\n", err.Error())
+	}
+}
+
+// Done replaces the status message with the final text right away, and stops the updates.
+func (p *Progress) Done(text string) error {
+	p.stop()
+
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	return p.bot.UpdatePost(p.handle, text)
+}
+
+// Delete removes the status message, and stops the updates.
+func (p *Progress) Delete() error {
+	p.stop()
+
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	return p.bot.DeletePost(p.handle)
+}
+
+func (p *Progress) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopped = true
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
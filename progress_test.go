@@ -0,0 +1,67 @@
+package mmbot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressThrottle(t *testing.T) {
+	b, adapter := newTestBot(t)
+	msg := newTestMessage(t, b, "build")
+
+	p, err := b.StartProgress(msg, "Working...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.interval = 50 * time.Millisecond
+
+	// the updates of an interval are posted as one edit with the latest text
+	for _, text := range []string{"1/3", "2/3", "3/3"} {
+		p.Update(text)
+	}
+	if n := adapter.updateCount(); n != 0 {
+		t.Fatalf("expected the updates to wait for the interval, got %d edits", n)
+	}
+
+	waitFor(t, "the throttled edit", func() bool { return adapter.updateCount() == 1 })
+	if post, err := adapter.GetPost(p.Post().ChannelId, p.Post().PostId); err != nil {
+		t.Fatal(err)
+	} else if post.Message != "3/3" {
+		t.Fatalf("expected the latest text, got %q", post.Message)
+	}
+
+	// Done is posted right away, and stops the updates
+	p.Update("late")
+	if err := p.Done("Done!"); err != nil {
+		t.Fatal(err)
+	}
+	p.Update("later")
+	time.Sleep(100 * time.Millisecond)
+
+	if n := adapter.updateCount(); n != 2 {
+		t.Fatalf("expected 2 edits, got %d", n)
+	}
+	if post, err := adapter.GetPost(p.Post().ChannelId, p.Post().PostId); err != nil {
+		t.Fatal(err)
+	} else if post.Message != "Done!" {
+		t.Fatalf("expected the final text, got %q", post.Message)
+	}
+}
+
+func TestProgressUnchanged(t *testing.T) {
+	b, adapter := newTestBot(t)
+	msg := newTestMessage(t, b, "build")
+
+	p, err := b.StartProgress(msg, "Working...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.interval = 10 * time.Millisecond
+
+	// the text posted already is not edited again
+	p.Update("Working...")
+	time.Sleep(50 * time.Millisecond)
+	if n := adapter.updateCount(); n != 0 {
+		t.Fatalf("expected no edit, got %d", n)
+	}
+}
@@ -0,0 +1,294 @@
+package mmbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/platform/model"
+)
+
+const (
+	QUEUE_SIZE             = 1000
+	QUEUE_INTERVAL         = 100 * time.Millisecond
+	QUEUE_CHANNEL_INTERVAL = 1 * time.Second
+	QUEUE_MAX_RETRIES      = 5
+	RETRY_MIN_WAIT         = 1 * time.Second
+	RETRY_MAX_WAIT         = 1 * time.Minute
+)
+
+// QueueConfig limits the rate of outgoing messages.
+type QueueConfig struct {
+	// minimum interval between any two messages
+	Interval time.Duration `yaml:"interval"`
+	// minimum interval between two messages in a channel
+	ChannelInterval time.Duration `yaml:"channel_interval"`
+	// retries on 429 and 5xx responses before giving up on a message
+	MaxRetries int `yaml:"max_retries"`
+	// messages waiting to be sent, beyond which Send fails
+	Size int `yaml:"size"`
+}
+
+// Delivery is the outcome of sending a message through the queue.
+type Delivery struct {
+	Message  *OutgoingMessage
+	Err      error
+	Attempts int
+
+	// Post is the post created with the API, or nil with the incoming webhook.
+	// Posts holds the posts of every part of a split message.
+	Post  *PostHandle
+	Posts []*PostHandle
+}
+
+type queuedMessage struct {
+	out      *OutgoingMessage
+	attempts int
+	retry    *backoff
+	done     func(handle *PostHandle, err error, attempts int)
+}
+
+// queue holds the outgoing messages of each channel in order.
+// The channels take turns, so that a busy channel does not hold up the others.
+type queue struct {
+	mu       sync.Mutex
+	channels map[string][]*queuedMessage
+	nextAt   map[string]time.Time
+	order    []string
+	size     int
+	wake     chan struct{}
+}
+
+func newQueue() *queue {
+	return &queue{
+		channels: map[string][]*queuedMessage{},
+		nextAt:   map[string]time.Time{},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+func (q *queue) push(channelId string, item *queuedMessage, limit int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.size >= limit {
+		return fmt.Errorf("The outbound queue is full with %d messages", q.size)
+	}
+
+	if _, ok := q.channels[channelId]; !ok {
+		q.order = append(q.order, channelId)
+	}
+	q.channels[channelId] = append(q.channels[channelId], item)
+	q.size++
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next returns the first message of a channel which is not waiting for its rate limit or a retry.
+// Otherwise it returns how long to wait for one, or zero if the queue is empty.
+func (q *queue) next(now time.Time) (string, *queuedMessage, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var wait time.Duration
+	for i, channelId := range q.order {
+		at := q.nextAt[channelId]
+		if !now.Before(at) {
+			// move the channel to the end of its turn
+			q.order = append(append(q.order[:i:i], q.order[i+1:]...), channelId)
+			return channelId, q.channels[channelId][0], 0
+		}
+
+		if d := at.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	return "", nil, wait
+}
+
+// pop removes the first message of the channel, which has been sent or given up.
+func (q *queue) pop(channelId string, nextAt time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.channels[channelId] = q.channels[channelId][1:]
+	q.size--
+	q.nextAt[channelId] = nextAt
+
+	if len(q.channels[channelId]) == 0 {
+		delete(q.channels, channelId)
+		for i, id := range q.order {
+			if id == channelId {
+				q.order = append(q.order[:i], q.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// delay keeps the first message of the channel for a retry.
+func (q *queue) delay(channelId string, nextAt time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextAt[channelId] = nextAt
+}
+
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// enqueue writes the message to the outbox and puts it in the queue of its channel.
+// done is called once it is sent or given up.
+func (b *BotKit) enqueue(out *OutgoingMessage, done func(handle *PostHandle, err error, attempts int)) error {
+	if fresh, err := b.saveOutbox(out); err != nil {
+		errorf("We failed to write the message '%s' to the outbox: %v\n", out.Id, err.Error())
+	} else if !fresh {
+		infof("Skip the message '%s' which is queued or sent already\n", out.Id)
+		done(nil, ErrDuplicate, 0)
+		return nil
+	}
+
+	return b.push(out, done)
+}
+
+func (b *BotKit) push(out *OutgoingMessage, done func(handle *PostHandle, err error, attempts int)) error {
+	item := &queuedMessage{
+		out:   out,
+		retry: &backoff{min: RETRY_MIN_WAIT, max: RETRY_MAX_WAIT},
+		done:  done,
+	}
+	return b.queue.push(out.ChannelId, item, b.Config().Queue.Size)
+}
+
+// runQueue sends the queued messages until the context is canceled.
+func (b *BotKit) runQueue(ctx context.Context) {
+	prune := time.NewTicker(OUTBOX_PRUNE_INTERVAL)
+	defer prune.Stop()
+
+	for {
+		config := b.Config().Queue
+
+		select {
+		case <-prune.C:
+			b.pruneOutbox()
+		default:
+		}
+
+		channelId, item, wait := b.queue.next(time.Now())
+		if item == nil {
+			var timeout <-chan time.Time
+			if wait > 0 {
+				timeout = time.After(wait)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-b.queue.wake:
+			case <-timeout:
+			case <-prune.C:
+				b.pruneOutbox()
+			}
+			continue
+		}
+
+		handle, err := b.deliver(item.out)
+		item.attempts++
+
+		// the server may have created the post before failing to answer
+		if err != nil && retryable(err) {
+			if post, ok := b.postedOutboxPosts(item.out.ChannelId, item.out.QueuedAt)[item.out.Id]; ok {
+				infof("The message '%s' was posted despite the error: %v\n", item.out.Id, err.Error())
+				handle, err = &PostHandle{PostId: post.Id, ChannelId: post.ChannelId}, nil
+			}
+		}
+
+		switch {
+		case err == nil:
+			b.queue.pop(channelId, time.Now().Add(config.ChannelInterval))
+			b.doneOutbox(item.out)
+			item.done(handle, nil, item.attempts)
+		case retryable(err) && item.attempts <= config.MaxRetries:
+			wait := item.retry.next()
+			infof("We failed to send a message to the channel '%s' and retry in %v: %v\n", item.out.Channel, wait, err.Error())
+			b.queue.delay(channelId, time.Now().Add(wait))
+		default:
+			b.queue.pop(channelId, time.Now().Add(config.ChannelInterval))
+			b.deadLetter(item, err)
+			b.dropOutbox(item.out)
+			item.done(nil, err, item.attempts)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(config.Interval):
+		}
+	}
+}
+
+// flushQueue waits until the queued messages are sent, or the context expires.
+func (b *BotKit) flushQueue(ctx context.Context) {
+	ticker := time.NewTicker(QUEUE_INTERVAL)
+	defer ticker.Stop()
+
+	for b.queue.len() > 0 {
+		select {
+		case <-ctx.Done():
+			errorf("We gave up sending %d queued messages\n", b.queue.len())
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// retryable returns true if the server was unavailable or asked to slow down.
+func retryable(err error) bool {
+	if appErr, ok := err.(*model.AppError); ok {
+		// the status code is not set when the server cannot be reached
+		return appErr.StatusCode == 0 || appErr.StatusCode == http.StatusTooManyRequests || appErr.StatusCode >= 500
+	}
+	return false
+}
+
+// deadLetter logs the message given up, and keeps it in Memory for the operator.
+func (b *BotKit) deadLetter(item *queuedMessage, err error) {
+	errorf("Dead letter: we gave up sending '%s' to the channel '%s' after %d attempts: %v\n", item.out.Text, item.out.Channel, item.attempts, err.Error())
+
+	data, _ := json.Marshal(item.out)
+	key := fmt.Sprintf("dead_letter:%d", time.Now().UnixNano())
+	if err := b.Memory.Put(b, key, string(data)); err != nil {
+		errorf("We failed to save the dead letter: %v\n", err.Error())
+	}
+}
+
+// DeadLetters returns the messages which could not be sent, by the time they were given up.
+func (b *BotKit) DeadLetters() (map[string]*OutgoingMessage, error) {
+	list, err := b.Memory.List(b)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := map[string]*OutgoingMessage{}
+	for key, val := range list {
+		if !strings.HasPrefix(key, "dead_letter:") {
+			continue
+		}
+
+		out := &OutgoingMessage{}
+		if err := json.Unmarshal([]byte(val), out); err == nil {
+			letters[key[len("dead_letter:"):]] = out
+		}
+	}
+	return letters, nil
+}
@@ -0,0 +1,172 @@
+package mmbot
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mattermost/platform/model"
+)
+
+func TestQueueOrder(t *testing.T) {
+	q := newQueue()
+	for _, item := range []struct{ channelId, id string }{
+		{"a", "a1"}, {"a", "a2"}, {"b", "b1"}, {"a", "a3"}, {"c", "c1"},
+	} {
+		if err := q.push(item.channelId, &queuedMessage{out: &OutgoingMessage{Id: item.id}}, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the channels take turns, and each sends its messages in order
+	now := time.Now()
+	for _, expected := range []string{"a1", "b1", "c1", "a2", "a3"} {
+		channelId, item, _ := q.next(now)
+		if item == nil || item.out.Id != expected {
+			t.Fatalf("expected %s, got %v", expected, item)
+		}
+		q.pop(channelId, now)
+	}
+
+	if _, item, wait := q.next(now); item != nil || wait != 0 || q.len() != 0 {
+		t.Fatalf("expected the queue to be empty, got %v", item)
+	}
+}
+
+func TestQueueDelay(t *testing.T) {
+	q := newQueue()
+	q.push("a", &queuedMessage{out: &OutgoingMessage{Id: "a1"}}, 10)
+	q.push("b", &queuedMessage{out: &OutgoingMessage{Id: "b1"}}, 10)
+
+	// a channel waiting for a retry does not hold up the others
+	now := time.Now()
+	q.delay("a", now.Add(time.Minute))
+	if channelId, item, _ := q.next(now); item == nil || item.out.Id != "b1" {
+		t.Fatalf("expected b1, got %v", item)
+	} else {
+		q.pop(channelId, now)
+	}
+
+	if _, item, wait := q.next(now); item != nil || wait != time.Minute {
+		t.Fatalf("expected to wait a minute, got %v and %v", item, wait)
+	}
+	if _, item, _ := q.next(now.Add(time.Minute)); item == nil || item.out.Id != "a1" {
+		t.Fatalf("expected a1 after its delay, got %v", item)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	q := newQueue()
+	for i := 0; i < 3; i++ {
+		if err := q.push("a", &queuedMessage{}, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.push("b", &queuedMessage{}, 3); err == nil {
+		t.Fatal("expected the queue to be full")
+	}
+}
+
+func TestSendInOrder(t *testing.T) {
+	b, adapter := newTestBot(t)
+
+	for i := 0; i < 5; i++ {
+		if err := b.SendMessage(fmt.Sprintf("message %d", i), "town-square", "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "the messages", func() bool { return len(adapter.Posts()) == 5 })
+	for i, post := range adapter.Posts() {
+		if expected := fmt.Sprintf("message %d", i); post.Message != expected {
+			t.Fatalf("expected %q, got %q", expected, post.Message)
+		}
+	}
+}
+
+func TestSendRetry(t *testing.T) {
+	b, adapter := newTestBot(t)
+	adapter.failures = 1
+
+	delivered := make(chan *Delivery, 1)
+	out := &OutgoingMessage{Text: "hello", Channel: "town-square", OnDelivery: func(d *Delivery) { delivered <- d }}
+	if err := b.Send(out); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case d := <-delivered:
+		if d.Err != nil || d.Attempts != 2 || d.Post == nil {
+			t.Fatalf("expected the message sent on the second attempt, got %+v", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message was not delivered")
+	}
+	if posts := adapter.Posts(); len(posts) != 1 || posts[0].Message != "hello" {
+		t.Fatalf("expected a single post, got %v", posts)
+	}
+}
+
+func TestSendNoRetryWhenPosted(t *testing.T) {
+	b, adapter := newTestBot(t)
+	adapter.failures = 1
+	adapter.createOnFailure = true
+
+	handle, err := b.SendAndWait(b.ctx, &OutgoingMessage{Text: "hello", Channel: "town-square"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the post created before the error is not sent again
+	if posts := adapter.Posts(); len(posts) != 1 || posts[0].Id != handle.PostId {
+		t.Fatalf("expected the single post to be found, got %v", posts)
+	}
+}
+
+func TestSendGiveUp(t *testing.T) {
+	b, adapter := newTestBot(t)
+	config := *b.Config()
+	config.Queue.MaxRetries = 0
+	b.configMu.Lock()
+	b.config = &config
+	b.configMu.Unlock()
+	adapter.failures = 1
+
+	if _, err := b.SendAndWait(b.ctx, &OutgoingMessage{Text: "hello", Channel: "town-square"}); err == nil {
+		t.Fatal("expected the message to be given up")
+	}
+
+	if letters, err := b.DeadLetters(); err != nil {
+		t.Fatal(err)
+	} else if len(letters) != 1 {
+		t.Fatalf("expected a dead letter, got %v", letters)
+	}
+}
+
+func TestSendRetryChannelLookup(t *testing.T) {
+	b, adapter := newTestBot(t)
+
+	// a channel the bot has not looked up yet, while the server is unavailable
+	channel := adapter.AddChannel("off-topic")
+	adapter.channelFailures = 1
+
+	delivered := make(chan int, 1)
+	out := &OutgoingMessage{Id: model.NewId(), Text: "hello", Channel: channel.Name, ChannelId: channel.Id, QueuedAt: model.GetMillis()}
+	if err := b.push(out, func(handle *PostHandle, err error, attempts int) {
+		if err != nil {
+			t.Errorf("expected the message to be sent, got %v", err)
+		}
+		delivered <- attempts
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case attempts := <-delivered:
+		if attempts != 2 {
+			t.Fatalf("expected the message sent on the second attempt, got %d", attempts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message was not delivered")
+	}
+}
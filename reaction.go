@@ -0,0 +1,107 @@
+package mmbot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mattermost/platform/model"
+)
+
+// Reaction is an emoji reaction a user added to a post, or removed from it.
+// It is the payload of the reaction_added and reaction_removed events subscribed with On.
+type Reaction struct {
+	// EmojiName is the name of the emoji without colons, such as "white_check_mark".
+	EmojiName string
+	// Added is false if the reaction was removed.
+	Added bool
+
+	PostId    string
+	ChannelId string
+	Channel   string
+	UserId    string
+	Username  string
+
+	// Post is the post reacted to, or nil if it cannot be read.
+	Post *model.Post
+	bot  *BotKit
+	ctx  context.Context
+
+	// the handlers of the reaction share it, the first one resolves it
+	resolved sync.Once
+}
+
+// Context is canceled when the bot is shutting down.
+func (r *Reaction) Context() context.Context {
+	return r.ctx
+}
+
+// Handle returns the handle of the post reacted to, to edit or delete it.
+func (r *Reaction) Handle() *PostHandle {
+	return &PostHandle{PostId: r.PostId, ChannelId: r.ChannelId}
+}
+
+// IsBotPost returns true if the post reacted to was created by the bot.
+func (r *Reaction) IsBotPost() bool {
+	return r.Post != nil && r.Post.UserId == r.bot.User.Id
+}
+
+// Rerun handles the command in the post reacted to again, as sent by the user who reacted,
+// so that the ACL and the middlewares apply to that user.
+func (r *Reaction) Rerun() error {
+	if r.Post == nil {
+		return fmt.Errorf("Post '%s' is not found", r.PostId)
+	}
+	return r.bot.rerun(r.Post, r.UserId)
+}
+
+// AddReaction reacts to the post with the emoji, such as "white_check_mark" or ":white_check_mark:".
+func (b *BotKit) AddReaction(handle *PostHandle, emojiName string) error {
+	reaction := &model.Reaction{UserId: b.User.Id, PostId: handle.PostId, EmojiName: strings.Trim(emojiName, ":")}
+	if err := b.adapter.SaveReaction(handle.ChannelId, reaction); err != nil {
+		return fmt.Errorf("We failed to add the reaction '%s' to the post '%s': %v", reaction.EmojiName, handle.PostId, err.Error())
+	}
+
+	return nil
+}
+
+// RemoveReaction removes the reaction of the bot with the emoji from the post.
+func (b *BotKit) RemoveReaction(handle *PostHandle, emojiName string) error {
+	reaction := &model.Reaction{UserId: b.User.Id, PostId: handle.PostId, EmojiName: strings.Trim(emojiName, ":")}
+	if err := b.adapter.DeleteReaction(handle.ChannelId, reaction); err != nil {
+		return fmt.Errorf("We failed to remove the reaction '%s' from the post '%s': %v", reaction.EmojiName, handle.PostId, err.Error())
+	}
+
+	return nil
+}
+
+// resolveReaction completes the reaction decoded from an event with the names and the post, once.
+func (b *BotKit) resolveReaction(r *Reaction) {
+	r.resolved.Do(func() {
+		b.completeReaction(r)
+	})
+}
+
+func (b *BotKit) completeReaction(r *Reaction) {
+	r.bot = b
+	r.ctx = b.ctx
+
+	if channel, err := b.getChannel(r.ChannelId); err != nil {
+		errorf("We cannnot get channel by id: %s\n", r.ChannelId)
+	} else {
+		r.Channel = channel.Name
+	}
+
+	if user, err := b.getUser(r.UserId); err != nil {
+		errorf("We cannnot get user by id: %s\n", r.UserId)
+	} else {
+		r.Username = user.Username
+	}
+
+	if post, err := b.adapter.GetPost(r.ChannelId, r.PostId); err != nil {
+		errorf("We cannnot get post by id: %s\n", r.PostId)
+	} else {
+		r.Post = post
+	}
+}
@@ -0,0 +1,139 @@
+package mmbot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/platform/model"
+)
+
+// listenTestBot handles the events of the in-memory server until the test ends.
+func listenTestBot(t *testing.T, b *BotKit, adapter *testAdapter) {
+	events, err := adapter.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.receive(ctx, events)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestReactionsDelivered(t *testing.T) {
+	b, adapter := newTestBot(t)
+	reactions := make(chan *Reaction, 10)
+	b.On(model.WEBSOCKET_EVENT_REACTION_ADDED, func(event *Event) error {
+		reactions <- event.Data.(*Reaction)
+		return nil
+	})
+	listenTestBot(t, b, adapter)
+
+	post, err := adapter.Receive("alice", "town-square", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter.AddChannel("off-topic")
+	other, err := adapter.Receive("alice", "off-topic", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the reaction of the bot and the reaction in a channel the bot is not a member of are skipped
+	if err := b.AddReaction(&PostHandle{PostId: post.Id, ChannelId: post.ChannelId}, ":eyes:"); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.React("alice", other.Id, "+1", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.React("alice", post.Id, "tada", true); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-reactions:
+		if r.EmojiName != "tada" || r.Username != "alice" || r.Channel != "town-square" {
+			t.Fatalf("unexpected reaction %+v", r)
+		}
+		if r.Post == nil || r.Post.Id != post.Id || r.IsBotPost() {
+			t.Fatalf("expected the post of alice, got %v", r.Post)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reaction was not delivered")
+	}
+
+	select {
+	case r := <-reactions:
+		t.Fatalf("unexpected reaction %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReactionRerun(t *testing.T) {
+	b, adapter := newTestBot(t)
+	adapter.AddUser("bob")
+
+	users := make(chan string, 10)
+	b.AddHandler(&funcHandler{fn: func(msg *Message) error {
+		users <- msg.Username + ": " + msg.Text
+		return nil
+	}})
+	b.On(model.WEBSOCKET_EVENT_REACTION_ADDED, func(event *Event) error {
+		return event.Data.(*Reaction).Rerun()
+	})
+	listenTestBot(t, b, adapter)
+
+	post, err := adapter.Receive("alice", "town-square", "bot ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case user := <-users:
+		if user != "alice: ping" {
+			t.Fatalf("expected the command of alice, got %q", user)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command was not handled")
+	}
+
+	// the command runs again as the user who reacted
+	if err := adapter.React("bob", post.Id, "repeat", true); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case user := <-users:
+		if user != "bob: ping" {
+			t.Fatalf("expected the command to be rerun by bob, got %q", user)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command was not rerun")
+	}
+}
+
+func TestReactionRerunNotCommand(t *testing.T) {
+	b, adapter := newTestBot(t)
+	post, err := adapter.Receive("alice", "town-square", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Reaction{PostId: post.Id, ChannelId: post.ChannelId, UserId: post.UserId}
+	b.resolveReaction(r)
+	if err := r.Rerun(); err == nil || !strings.Contains(err.Error(), "is not a command") {
+		t.Fatalf("expected the post not to be rerun, got %v", err)
+	}
+
+	r = &Reaction{PostId: "missing", ChannelId: post.ChannelId, UserId: post.UserId}
+	b.resolveReaction(r)
+	if err := r.Rerun(); err == nil || !strings.Contains(err.Error(), "is not found") {
+		t.Fatalf("expected the missing post not to be rerun, got %v", err)
+	}
+}
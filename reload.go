@@ -0,0 +1,102 @@
+package mmbot
+
+import (
+	"context"
+)
+
+// Reconfigurable is implemented by plugins which apply their settings while the bot is running.
+// Reconfigure is called with the new configuration after the bot reloads it.
+// A plugin returning an error should keep working with its previous settings.
+type Reconfigurable interface {
+	Reconfigure(config *Config) error
+}
+
+// Reload reads the configuration file again and applies the changes right away,
+// including the webhook, triggers, hear, queue and catchup settings, which are read for every message.
+// The connection settings and leveldb_path keep their values until the bot is restarted.
+func (b *BotKit) Reload() error {
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	old := b.Config()
+	config.keepRestartSettings(old)
+
+	level, _ := ParseLogLevel(config.LogLevel)
+	SetLogLevel(level)
+
+	b.configMu.Lock()
+	b.config = config
+	b.configMu.Unlock()
+	infof("Reloaded the configuration\n")
+
+	b.applyEnabledPlugins(old, config)
+
+	for _, plugin := range b.Plugins() {
+		if reconfigurable, ok := pluginOf(plugin).(Reconfigurable); ok {
+			if err := reconfigurable.Reconfigure(config); err != nil {
+				errorf("Plugin %T failed to apply the new configuration: %v\n", pluginOf(plugin), err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// keepRestartSettings restores the settings which cannot change while the bot is running, and logs them.
+func (c *Config) keepRestartSettings(old *Config) {
+	settings := []struct {
+		name     string
+		new, old *string
+	}{
+		{"endpoint", &c.Endpoint, &old.Endpoint},
+		{"account", &c.Account, &old.Account},
+		{"password", &c.Password, &old.Password},
+		{"token", &c.Token, &old.Token},
+		{"teamname", &c.Teamname, &old.Teamname},
+		{"leveldb_path", &c.LevelDBPath, &old.LevelDBPath},
+	}
+
+	for _, setting := range settings {
+		if *setting.new != *setting.old {
+			infof("The setting '%s' has changed, but it needs a restart to take effect\n", setting.name)
+			*setting.new = *setting.old
+		}
+	}
+}
+
+// applyEnabledPlugins starts the plugins added to the enable list and stops the removed ones.
+func (b *BotKit) applyEnabledPlugins(old, config *Config) {
+	b.pluginsMu.RLock()
+	plugins := append([]Handler{}, b.plugins...)
+	b.pluginsMu.RUnlock()
+
+	for _, plugin := range plugins {
+		name := PluginName(plugin)
+		wasEnabled, enabled := old.PluginEnabled(name), config.PluginEnabled(name)
+
+		switch {
+		case !wasEnabled && enabled:
+			infof("Enable the plugin '%s'\n", name)
+			// plugins are started when the bot connects for the first time, queue it until then
+			b.pluginsMu.Lock()
+			ctx := b.pluginsCtx
+			if ctx == nil {
+				b.pendingStart = append(b.pendingStart, plugin)
+			}
+			b.pluginsMu.Unlock()
+
+			if ctx != nil {
+				b.startPlugin(ctx, plugin)
+			}
+		case wasEnabled && !enabled:
+			infof("Disable the plugin '%s'\n", name)
+			stopCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+			b.stopPlugin(stopCtx, plugin)
+			cancel()
+		}
+	}
+}
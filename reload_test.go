@@ -0,0 +1,99 @@
+package mmbot
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// lifecyclePlugin counts the calls of its lifecycle methods.
+type lifecyclePlugin struct {
+	mu     sync.Mutex
+	inits  int
+	starts int
+}
+
+func (p *lifecyclePlugin) Handle(msg *Message) error { return nil }
+func (p *lifecyclePlugin) Usage() string             { return "" }
+
+func (p *lifecyclePlugin) Init() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inits++
+	return nil
+}
+
+func (p *lifecyclePlugin) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.starts++
+	return nil
+}
+
+func (p *lifecyclePlugin) counts() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inits, p.starts
+}
+
+// setTestConfig replaces the configuration of the bot, as Reload does.
+func setTestConfig(b *BotKit, change func(config *Config)) *Config {
+	old := b.Config()
+	config := *old
+	change(&config)
+
+	b.configMu.Lock()
+	b.config = &config
+	b.configMu.Unlock()
+	return old
+}
+
+func TestEnablePluginBeforeConnect(t *testing.T) {
+	b, _ := newTestBot(t)
+	plugin := &lifecyclePlugin{}
+	b.AddHandler(plugin)
+
+	setTestConfig(b, func(config *Config) { config.EnabledPlugins = []string{"other"} })
+	b.initPlugins()
+
+	// the plugin is enabled by a reload before the bot connects
+	old := setTestConfig(b, func(config *Config) { config.EnabledPlugins = []string{"other", PluginName(plugin)} })
+	b.applyEnabledPlugins(old, b.Config())
+	if inits, starts := plugin.counts(); inits != 0 || starts != 0 {
+		t.Fatalf("expected the plugin to wait for the connection, got %d inits and %d starts", inits, starts)
+	}
+
+	b.startPlugins(context.Background())
+	b.startPlugin(context.Background(), plugin)
+	if inits, starts := plugin.counts(); inits != 1 || starts != 1 {
+		t.Fatalf("expected the plugin to be initialized and started once, got %d inits and %d starts", inits, starts)
+	}
+}
+
+func TestReloadKeepsRestartSettings(t *testing.T) {
+	b, _ := newTestBot(t)
+	old := b.Config()
+
+	writeTestConfig(t, `
+endpoint: https://chat.example.com
+token: secret
+leveldb_path: /elsewhere
+log_level: error
+queue:
+  size: 5
+triggers:
+  prefixes: ["!"]
+`)
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the connection settings and the database need a restart, the others apply right away
+	config := b.Config()
+	if config.Endpoint != old.Endpoint || config.Token != old.Token || config.LevelDBPath != old.LevelDBPath {
+		t.Fatalf("expected the restart settings to be kept, got %s, %s and %s", config.Endpoint, config.Token, config.LevelDBPath)
+	}
+	if config.Queue.Size != 5 || len(config.Triggers.Prefixes) != 1 {
+		t.Fatalf("expected the new settings, got %+v and %+v", config.Queue, config.Triggers)
+	}
+}
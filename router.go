@@ -0,0 +1,441 @@
+package mmbot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	argLiteral  = "literal"
+	argString   = "string"
+	argInt      = "int"
+	argFloat    = "float"
+	argDuration = "duration"
+	argBool     = "bool"
+	argBacktick = "backtick"
+	argRest     = "rest"
+)
+
+var errNoMatch = errors.New("command does not match")
+
+// CommandFunc handles a command matched by a Router.
+type CommandFunc func(msg *Message, args Args) error
+
+// HelpEntry describes a command for the help message.
+type HelpEntry struct {
+	Usage       string
+	Description string
+}
+
+func (e HelpEntry) String() string {
+	if e.Description == "" {
+		return e.Usage
+	}
+	return fmt.Sprintf("%s: %s", e.Usage, e.Description)
+}
+
+// Router dispatches messages to the commands registered with a pattern such as
+//
+//	cron add <spec:backtick> <task:rest> [--quiet] [--repeat=<count:int>]
+//
+// Words are matched literally and case-insensitively. <name> is a single word or a quoted string,
+// and <name:type> is converted to int, float or duration. <name:backtick> is a backticked string
+// and <name:rest> takes the rest of the message. Arguments in brackets are optional, and flags
+// (--name or --name=<value:type>) may appear anywhere after the first word.
+type Router struct {
+	commands []*Command
+	hearings []*hearing
+	topLevel bool
+}
+
+func NewRouter() *Router {
+	return &Router{commands: []*Command{}}
+}
+
+// Command registers a handler for the pattern. It panics if the pattern is invalid.
+func (r *Router) Command(pattern, description string, handler CommandFunc) *Command {
+	cmd := newCommand(pattern, description, handler)
+	r.commands = append(r.commands, cmd)
+	return cmd
+}
+
+// SetTopLevel makes the commands reply with top-level posts instead of replies in the thread.
+func (r *Router) SetTopLevel(topLevel bool) {
+	r.topLevel = topLevel
+}
+
+// Handle runs the first command matching the message.
+func (r *Router) Handle(msg *Message) error {
+	for _, cmd := range r.commands {
+		args, err := cmd.Parse(msg.Text)
+		if err == errNoMatch {
+			continue
+		}
+
+		msg.MarkHandled()
+		if err != nil {
+			// the user meant this command, show them how to use it
+			return msg.Reply(fmt.Sprintf("%v\nUsage: `%s`", err.Error(), cmd.Usage()))
+		}
+
+		msg.TopLevel = msg.TopLevel || r.topLevel || cmd.topLevel
+		return cmd.handler(msg, args)
+	}
+
+	return nil
+}
+
+func (r *Router) Help() []HelpEntry {
+	entries := []HelpEntry{}
+	for _, cmd := range r.commands {
+		entries = append(entries, HelpEntry{cmd.Usage(), cmd.Description})
+	}
+	return entries
+}
+
+func (r *Router) Usage() string {
+	usages := []string{}
+	for _, entry := range r.Help() {
+		usages = append(usages, entry.String())
+	}
+	return strings.Join(usages, "\n")
+}
+
+type Command struct {
+	Pattern     string
+	Description string
+
+	handler  CommandFunc
+	params   []*param
+	flags    []*param
+	topLevel bool
+}
+
+type param struct {
+	name     string
+	kind     string
+	optional bool
+}
+
+func newCommand(pattern, description string, handler CommandFunc) *Command {
+	c := &Command{
+		Pattern:     pattern,
+		Description: description,
+		handler:     handler,
+		params:      []*param{},
+		flags:       []*param{},
+	}
+
+	for _, field := range strings.Fields(pattern) {
+		optional := strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]")
+		if optional {
+			field = field[1 : len(field)-1]
+		}
+
+		if strings.HasPrefix(field, "--") {
+			flag := &param{name: field[2:], kind: argBool, optional: true}
+			if i := strings.Index(field, "="); i >= 0 {
+				flag.name = field[2:i]
+				flag.kind = parseParam(pattern, field[i+1:]).kind
+			}
+			c.flags = append(c.flags, flag)
+			continue
+		}
+
+		p := parseParam(pattern, field)
+		p.optional = optional
+		c.params = append(c.params, p)
+	}
+
+	if len(c.params) == 0 || c.params[0].kind != argLiteral {
+		panic(fmt.Sprintf("mmbot: command '%s' must start with a word", pattern))
+	}
+
+	return c
+}
+
+func parseParam(pattern, field string) *param {
+	if !strings.HasPrefix(field, "<") || !strings.HasSuffix(field, ">") {
+		return &param{name: field, kind: argLiteral}
+	}
+
+	p := &param{name: field[1 : len(field)-1], kind: argString}
+	if i := strings.Index(p.name, ":"); i >= 0 {
+		p.name, p.kind = p.name[:i], p.name[i+1:]
+	}
+
+	switch p.kind {
+	case argString, argInt, argFloat, argDuration, argBacktick, argRest:
+		return p
+	default:
+		panic(fmt.Sprintf("mmbot: unknown argument type '%s' in command '%s'", p.kind, pattern))
+	}
+}
+
+// Usage returns the syntax of the command.
+func (c *Command) Usage() string {
+	words := []string{}
+	for _, p := range c.params {
+		words = append(words, p.usage())
+	}
+	for _, flag := range c.flags {
+		words = append(words, flag.flagUsage())
+	}
+	return strings.Join(words, " ")
+}
+
+// SetTopLevel makes the command reply with top-level posts instead of replies in the thread.
+func (c *Command) SetTopLevel(topLevel bool) *Command {
+	c.topLevel = topLevel
+	return c
+}
+
+func (c *Command) flag(name string) *param {
+	for _, flag := range c.flags {
+		if flag.name == name {
+			return flag
+		}
+	}
+	return nil
+}
+
+func (p *param) usage() string {
+	var usage string
+	switch {
+	case p.kind == argLiteral:
+		return p.name
+	case p.kind == argBacktick:
+		usage = fmt.Sprintf("`<%s>`", p.name)
+	default:
+		usage = fmt.Sprintf("<%s>", p.name)
+	}
+
+	if p.optional {
+		usage = fmt.Sprintf("[%s]", usage)
+	}
+	return usage
+}
+
+func (p *param) flagUsage() string {
+	if p.kind == argBool {
+		return fmt.Sprintf("[--%s]", p.name)
+	}
+	return fmt.Sprintf("[--%s=<%s>]", p.name, p.name)
+}
+
+// Parse matches the text against the command. It returns errNoMatch if the text is not this command.
+func (c *Command) Parse(text string) (Args, error) {
+	args := Args{}
+	s := &scanner{text: text}
+
+	for i, p := range c.params {
+		if i > 0 {
+			if err := c.parseFlags(s, args); err != nil {
+				return nil, err
+			}
+		}
+
+		switch p.kind {
+		case argLiteral:
+			if word, err := s.word(); err != nil || !strings.EqualFold(word, p.name) {
+				return nil, errNoMatch
+			}
+			continue
+		case argRest:
+			if rest := s.rest(); rest != "" {
+				args[p.name] = rest
+				continue
+			}
+		case argBacktick:
+			if value, err := s.backtick(); err != nil {
+				return nil, fmt.Errorf("%v for <%s>", err.Error(), p.name)
+			} else if value != "" {
+				args[p.name] = value
+				continue
+			}
+		default:
+			if word, err := s.word(); err != nil {
+				return nil, fmt.Errorf("%v for <%s>", err.Error(), p.name)
+			} else if word != "" {
+				value, err := convertArg(p.kind, word)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid %s '%s' for <%s>", p.kind, word, p.name)
+				}
+				args[p.name] = value
+				continue
+			}
+		}
+
+		if !p.optional {
+			return nil, fmt.Errorf("Missing <%s>", p.name)
+		}
+	}
+
+	if err := c.parseFlags(s, args); err != nil {
+		return nil, err
+	}
+
+	if rest := s.rest(); rest != "" {
+		return nil, fmt.Errorf("Unexpected '%s'", rest)
+	}
+
+	return args, nil
+}
+
+func (c *Command) parseFlags(s *scanner, args Args) error {
+	for s.skipSpaces(); strings.HasPrefix(s.text[s.pos:], "--"); s.skipSpaces() {
+		pos := s.pos
+		word, _ := s.word()
+
+		name, value := word[2:], ""
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+
+		flag := c.flag(name)
+		if flag == nil {
+			// not a flag of this command, leave it to the arguments
+			s.pos = pos
+			return nil
+		}
+
+		if flag.kind == argBool {
+			args[flag.name] = true
+			continue
+		}
+
+		if value == "" {
+			value, _ = s.word()
+		}
+
+		converted, err := convertArg(flag.kind, value)
+		if err != nil || value == "" {
+			return fmt.Errorf("Invalid %s '%s' for --%s", flag.kind, value, flag.name)
+		}
+		args[flag.name] = converted
+	}
+
+	return nil
+}
+
+func convertArg(kind, value string) (interface{}, error) {
+	switch kind {
+	case argInt:
+		return strconv.Atoi(value)
+	case argFloat:
+		return strconv.ParseFloat(value, 64)
+	case argDuration:
+		return time.ParseDuration(value)
+	default:
+		return value, nil
+	}
+}
+
+// Args holds the arguments of a matched command by name.
+type Args map[string]interface{}
+
+func (a Args) Has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+func (a Args) String(name string) string {
+	value, _ := a[name].(string)
+	return value
+}
+
+func (a Args) Int(name string) int {
+	value, _ := a[name].(int)
+	return value
+}
+
+func (a Args) Float(name string) float64 {
+	value, _ := a[name].(float64)
+	return value
+}
+
+func (a Args) Duration(name string) time.Duration {
+	value, _ := a[name].(time.Duration)
+	return value
+}
+
+func (a Args) Bool(name string) bool {
+	value, _ := a[name].(bool)
+	return value
+}
+
+type scanner struct {
+	text string
+	pos  int
+}
+
+func (s *scanner) skipSpaces() {
+	for s.pos < len(s.text) {
+		r, size := utf8.DecodeRuneInString(s.text[s.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		s.pos += size
+	}
+}
+
+// word reads a single word or a quoted string.
+func (s *scanner) word() (string, error) {
+	s.skipSpaces()
+	if s.pos >= len(s.text) {
+		return "", nil
+	}
+
+	if quote := s.text[s.pos]; quote == '"' || quote == '\'' {
+		end := strings.IndexByte(s.text[s.pos+1:], quote)
+		if end < 0 {
+			return "", fmt.Errorf("Unterminated quote")
+		}
+		word := s.text[s.pos+1 : s.pos+1+end]
+		s.pos += end + 2
+		return word, nil
+	}
+
+	start := s.pos
+	for s.pos < len(s.text) {
+		// decode the rune, as a byte of a multibyte character may look like a space
+		r, size := utf8.DecodeRuneInString(s.text[s.pos:])
+		if unicode.IsSpace(r) {
+			break
+		}
+		s.pos += size
+	}
+	return s.text[start:s.pos], nil
+}
+
+// backtick reads a string surrounded by backticks.
+func (s *scanner) backtick() (string, error) {
+	s.skipSpaces()
+	if s.pos >= len(s.text) {
+		return "", nil
+	}
+
+	if s.text[s.pos] != '`' {
+		return "", fmt.Errorf("Missing backtick")
+	}
+
+	end := strings.IndexByte(s.text[s.pos+1:], '`')
+	if end < 0 {
+		return "", fmt.Errorf("Unterminated backtick")
+	}
+	value := strings.TrimSpace(s.text[s.pos+1 : s.pos+1+end])
+	s.pos += end + 2
+	return value, nil
+}
+
+// rest reads the remaining text.
+func (s *scanner) rest() string {
+	rest := strings.TrimSpace(s.text[s.pos:])
+	s.pos = len(s.text)
+	return rest
+}
@@ -0,0 +1,136 @@
+package mmbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandParse(t *testing.T) {
+	cmd := newCommand("deploy <app> [<count:int>] [--force] [--timeout=<value:duration>]", "", nil)
+
+	tests := []struct {
+		text string
+		args Args
+	}{
+		{"deploy web", Args{"app": "web"}},
+		{"DEPLOY web 3", Args{"app": "web", "count": 3}},
+		{"deploy   web  --force 3", Args{"app": "web", "count": 3, "force": true}},
+		{"deploy web --timeout=5m", Args{"app": "web", "timeout": 5 * time.Minute}},
+		{"deploy web --timeout 30s --force", Args{"app": "web", "timeout": 30 * time.Second, "force": true}},
+		{`deploy "my app"`, Args{"app": "my app"}},
+		{"deploy 日本語", Args{"app": "日本語"}},
+		{"deploy　アプリ　2", Args{"app": "アプリ", "count": 2}},
+	}
+
+	for _, test := range tests {
+		args, err := cmd.Parse(test.text)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.text, err)
+			continue
+		}
+		if len(args) != len(test.args) {
+			t.Errorf("%q: expected %v, got %v", test.text, test.args, args)
+			continue
+		}
+		for name, value := range test.args {
+			if args[name] != value {
+				t.Errorf("%q: expected %s=%v, got %v", test.text, name, value, args[name])
+			}
+		}
+	}
+}
+
+func TestCommandParseErrors(t *testing.T) {
+	cmd := newCommand("deploy <app> [<count:int>] [--timeout=<value:duration>]", "", nil)
+
+	tests := []struct {
+		text string
+		err  string
+	}{
+		{"deploy", "Missing <app>"},
+		{"deploy web many", "Invalid int 'many' for <count>"},
+		{"deploy web --timeout=soon", "Invalid duration 'soon' for --timeout"},
+		{"deploy web 1 extra", "Unexpected 'extra'"},
+		{`deploy "web`, "for <app>"},
+	}
+
+	for _, test := range tests {
+		if _, err := cmd.Parse(test.text); err == nil || err == errNoMatch {
+			t.Errorf("%q: expected an error, got %v", test.text, err)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expected '%s', got '%v'", test.text, test.err, err)
+		}
+	}
+
+	for _, text := range []string{"", "undeploy web", "deployed web"} {
+		if _, err := cmd.Parse(text); err != errNoMatch {
+			t.Errorf("%q: expected no match, got %v", text, err)
+		}
+	}
+}
+
+func TestCommandParseRest(t *testing.T) {
+	cmd := newCommand("echo <text:rest>", "", nil)
+	if args, err := cmd.Parse("echo  こんにちは  世界 "); err != nil {
+		t.Fatal(err)
+	} else if args.String("text") != "こんにちは  世界" {
+		t.Fatalf("unexpected rest %q", args.String("text"))
+	}
+
+	cmd = newCommand("run <code:backtick>", "", nil)
+	if args, err := cmd.Parse("run `echo 1`"); err != nil {
+		t.Fatal(err)
+	} else if args.String("code") != "echo 1" {
+		t.Fatalf("unexpected code %q", args.String("code"))
+	}
+}
+
+func TestScannerMultibyte(t *testing.T) {
+	// "々" is encoded with the byte 0x85, which is a space as a rune
+	s := &scanner{text: "　 日本　人々 "}
+	for _, expected := range []string{"日本", "人々", ""} {
+		if word, err := s.word(); err != nil {
+			t.Fatal(err)
+		} else if word != expected {
+			t.Fatalf("expected %q, got %q", expected, word)
+		}
+	}
+}
+
+func TestRouterHandle(t *testing.T) {
+	b, adapter := newTestBot(t)
+
+	var got Args
+	r := NewRouter()
+	r.Command("add <a:int> <b:int>", "adds numbers", func(msg *Message, args Args) error {
+		got = args
+		return nil
+	})
+
+	msg := newTestMessage(t, b, "add 1 2")
+	if err := r.Handle(msg); err != nil {
+		t.Fatal(err)
+	}
+	if !msg.handled || got.Int("a") != 1 || got.Int("b") != 2 {
+		t.Fatalf("expected the command to handle the message, got %v", got)
+	}
+
+	msg = newTestMessage(t, b, "hello")
+	if err := r.Handle(msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.handled {
+		t.Fatal("expected the message not to be handled")
+	}
+
+	// a mistyped command is answered with its usage
+	msg = newTestMessage(t, b, "add 1 two")
+	if err := r.Handle(msg); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the usage reply", func() bool { return len(adapter.Posts()) == 1 })
+	if text := adapter.Posts()[0].Message; !strings.Contains(text, "Invalid int 'two' for <b>") || !strings.Contains(text, "Usage: `add <a> <b>`") {
+		t.Fatalf("unexpected reply %q", text)
+	}
+}
@@ -0,0 +1,307 @@
+package mmbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/mattermost/platform/model"
+)
+
+// OutgoingMessage is a message sent by the bot.
+type OutgoingMessage struct {
+	Text string
+
+	// Channel is the name of the channel, used when ChannelId is empty.
+	Channel   string
+	ChannelId string
+
+	// RootId is the post to reply to in its thread. It is empty for a top-level post.
+	RootId string
+
+	// Username and IconUrl override the bot profile. With the API, they are sent as the override props,
+	// which the server shows if "Enable integrations to override usernames" and "profile picture icons" are on.
+	Username string
+	IconUrl  string
+
+	// Attachments and Props are sent with the post. See MessageBuilder.
+	Attachments []*model.SlackAttachment
+	Props       map[string]interface{}
+
+	// Id deduplicates the message: it is dropped with ErrDuplicate if a message with the same id is queued,
+	// or was sent in the last 24 hours. It is generated if empty.
+	Id       string
+	QueuedAt int64
+
+	// UseAPI sends the message with the API even if the incoming webhook is set,
+	// so that the post can be tracked, edited or deleted later.
+	UseAPI bool
+
+	// OnDelivery is called when the message is sent, given up after retries, or fails to be queued.
+	// It is not called for messages resent after a restart.
+	OnDelivery func(delivery *Delivery) `json:"-"`
+}
+
+// PostHandle identifies a post created by the bot.
+type PostHandle struct {
+	PostId    string
+	ChannelId string
+}
+
+// ErrNoHandle is returned by SendAndWait when the message was sent with the incoming webhook,
+// which does not tell the post it created. Set UseAPI on the message to get the handle.
+var ErrNoHandle = errors.New("The message was sent without a post handle")
+
+// ErrDuplicate ends the delivery of a message dropped because its id is queued or was sent already.
+var ErrDuplicate = errors.New("The message is queued or sent already")
+
+// SendMessage queues the text and returns without waiting for the post, fire-and-forget.
+// Use SendAndWait to get the handle of the post.
+func (b *BotKit) SendMessage(text, channel, username, iconUrl string) error {
+	return b.Send(&OutgoingMessage{Text: text, Channel: channel, Username: username, IconUrl: iconUrl})
+}
+
+// Reply answers the message in its thread, or with a top-level post if the command asks for it.
+func (b *BotKit) Reply(msg *Message, text, username, iconUrl string) error {
+	out := NewReply(msg, text)
+	out.Username = username
+	out.IconUrl = iconUrl
+	return b.Send(out)
+}
+
+// ReplyMessage sends the message built by MessageBuilder as a reply, like Reply.
+func (b *BotKit) ReplyMessage(msg *Message, out *OutgoingMessage) error {
+	reply := *out
+	reply.Channel = msg.Channel
+	reply.ChannelId = msg.ChannelId
+	reply.RootId = NewReply(msg, "").RootId
+	return b.Send(&reply)
+}
+
+// NewReply returns a message answering msg in its thread, or with a top-level post if the command asks for it.
+func NewReply(msg *Message, text string) *OutgoingMessage {
+	out := &OutgoingMessage{Text: text, Channel: msg.Channel, ChannelId: msg.ChannelId}
+	if !msg.TopLevel {
+		out.RootId = msg.ThreadId()
+	}
+	return out
+}
+
+// SendAndWait sends the message through the queue and waits until it is delivered.
+// It returns the handle of the post, or of the first part of a split message.
+// ErrNoHandle means the message was sent, but the post is unknown.
+func (b *BotKit) SendAndWait(ctx context.Context, out *OutgoingMessage) (*PostHandle, error) {
+	delivered := make(chan *Delivery, 1)
+
+	wait := *out
+	wait.OnDelivery = func(delivery *Delivery) {
+		if out.OnDelivery != nil {
+			out.OnDelivery(delivery)
+		}
+		delivered <- delivery
+	}
+
+	if err := b.Send(&wait); err != nil {
+		return nil, err
+	}
+
+	select {
+	case delivery := <-delivered:
+		if delivery.Err != nil {
+			return nil, delivery.Err
+		} else if delivery.Post == nil {
+			return nil, ErrNoHandle
+		}
+		return delivery.Post, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Send puts the message in the outbound queue, split into numbered parts at line boundaries
+// if it is too long for a post. The parts are sent in order, and the attachments come with the last one.
+// It fails only if the channel is unknown or the queue is full. Use OnDelivery to learn the outcome.
+func (b *BotKit) Send(out *OutgoingMessage) error {
+	var channel *model.Channel
+	if out.ChannelId != "" {
+		channel, _ = b.getChannel(out.ChannelId)
+	} else {
+		channel, _ = b.getChannelByName(out.Channel)
+	}
+
+	if channel == nil && out.ChannelId != "" {
+		return fmt.Errorf("Channel '%s' is not found", out.ChannelId)
+	} else if channel == nil {
+		return fmt.Errorf("Channel '%s' is not found", out.Channel)
+	}
+
+	parts := splitMessage(out.Text, MESSAGE_MAX_RUNES)
+	ids := outboxIds(out.Id, len(parts))
+	done := b.deliveryOf(out, len(parts))
+	for i, text := range parts {
+		i := i
+		part := *out
+		part.Text = text
+		part.Id = ids[i]
+		part.QueuedAt = model.GetMillis()
+		part.Channel = channel.Name
+		part.ChannelId = channel.Id
+		if i < len(parts)-1 {
+			part.Attachments = nil
+		}
+
+		partDone := func(handle *PostHandle, err error, attempts int) {
+			done(i, handle, err, attempts)
+		}
+		if err := b.enqueue(&part, partDone); err != nil {
+			if len(parts) > 1 {
+				err = fmt.Errorf("We failed to queue the part %d of %d: %v", i+1, len(parts), err.Error())
+			}
+
+			// the parts queued already are sent, the delivery ends with the error
+			for j := i; j < len(parts); j++ {
+				done(j, nil, err, 0)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// deliveryOf calls OnDelivery once all parts of the message are sent or given up.
+func (b *BotKit) deliveryOf(out *OutgoingMessage, parts int) func(int, *PostHandle, error, int) {
+	var mu sync.Mutex
+	delivery := &Delivery{Message: out, Posts: make([]*PostHandle, parts)}
+
+	return func(part int, handle *PostHandle, err error, attempts int) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		parts--
+		delivery.Posts[part] = handle
+		if part == 0 {
+			delivery.Post = handle
+		}
+		delivery.Attempts += attempts
+		if delivery.Err == nil {
+			delivery.Err = err
+		}
+
+		if parts == 0 && out.OnDelivery != nil {
+			out.OnDelivery(delivery)
+		}
+	}
+}
+
+// deliver sends the message to the server right away.
+// The handle is nil if the message was sent with the incoming webhook.
+func (b *BotKit) deliver(out *OutgoingMessage) (*PostHandle, error) {
+	// keep the error of the server, which tells whether to retry
+	channel, err := b.getChannel(out.ChannelId)
+	if err != nil {
+		return nil, err
+	}
+
+	// if the webhook id is not specified, bot will try to send message with api driver
+	if b.Config().Webhook == "" {
+		infof("Incoming Webhook ID is not set. Try to send message with API driver.\n")
+		return b.sendWithAPI(out, channel)
+	}
+
+	// incoming webhooks cannot post to direct or group messages, nor reply in threads
+	if out.UseAPI || channel.IsGroupOrDirect() || out.RootId != "" {
+		return b.sendWithAPI(out, channel)
+	}
+
+	return nil, b.sendWithWebhook(out, channel)
+}
+
+// props returns the props of the post, marked with the outbox id to find it after a restart.
+func (out *OutgoingMessage) props() map[string]interface{} {
+	props := map[string]interface{}{}
+	for key, value := range out.Props {
+		props[key] = value
+	}
+	if out.Id != "" {
+		props[OUTBOX_ID_PROP] = out.Id
+	}
+	return props
+}
+
+func (b *BotKit) sendWithAPI(out *OutgoingMessage, channel *model.Channel) (*PostHandle, error) {
+	post := &model.Post{Message: out.Text, ChannelId: channel.Id, RootId: out.RootId}
+	for key, value := range out.props() {
+		post.AddProp(key, value)
+	}
+
+	// the overrides are only shown on the posts from integrations
+	if out.Username != "" || out.IconUrl != "" {
+		post.AddProp("from_webhook", "true")
+	}
+	if out.Username != "" {
+		post.AddProp("override_username", out.Username)
+	}
+	if out.IconUrl != "" {
+		post.AddProp("override_icon_url", out.IconUrl)
+	}
+	if len(out.Attachments) > 0 {
+		// rendered like the attachments of incoming webhooks
+		post.Type = model.POST_SLACK_ATTACHMENT
+		post.AddProp("attachments", out.Attachments)
+	}
+	return b.createPost(post)
+}
+
+func (b *BotKit) sendWithWebhook(out *OutgoingMessage, channel *model.Channel) error {
+	message := map[string]interface{}{"text": out.Text, "channel": channel.Name}
+
+	if out.Username != "" {
+		message["username"] = out.Username
+	} else {
+		message["username"] = b.User.Username
+	}
+
+	if out.IconUrl != "" {
+		message["icon_url"] = out.IconUrl
+	}
+
+	if len(out.Attachments) > 0 {
+		message["attachments"] = out.Attachments
+	}
+
+	if props := out.props(); len(props) > 0 {
+		message["props"] = props
+	}
+
+	// send message with incoming webhook
+	payload, _ := json.Marshal(message)
+	content := fmt.Sprintf("payload=%s", url.QueryEscape(string(payload)))
+	return b.adapter.PostToWebhook(b.Config().Webhook, content)
+}
+
+// SendMessageWithAPI creates the post right away, bypassing the outbound queue.
+func (b *BotKit) SendMessageWithAPI(post *model.Post) error {
+	_, err := b.SendPostWithAPI(post)
+	return err
+}
+
+// SendPostWithAPI creates the post right away like SendMessageWithAPI, and returns its handle.
+func (b *BotKit) SendPostWithAPI(post *model.Post) (*PostHandle, error) {
+	// send message with api driver
+	if handle, err := b.createPost(post); err != nil {
+		return nil, fmt.Errorf("We failed to send a message with api driver: %v", err.Error())
+	} else {
+		return handle, nil
+	}
+}
+
+func (b *BotKit) createPost(post *model.Post) (*PostHandle, error) {
+	if created, err := b.adapter.CreatePost(post); err != nil {
+		return nil, err
+	} else {
+		return &PostHandle{PostId: created.Id, ChannelId: created.ChannelId}, nil
+	}
+}
@@ -0,0 +1,105 @@
+package mmbot
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattermost/platform/model"
+)
+
+const (
+	MESSAGE_MAX_RUNES = model.POST_MESSAGE_MAX_RUNES
+
+	// room left in each part for its number, such as "(12/34)"
+	splitNumberRunes = 16
+)
+
+// splitMessage splits the text into parts of at most max runes at line boundaries.
+// A code block spanning parts is closed at the end of a part and opened again in the next one.
+// The parts are numbered if there are more than one.
+func splitMessage(text string, max int) []string {
+	if utf8.RuneCountInString(text) <= max {
+		return []string{text}
+	}
+
+	limit := max - splitNumberRunes
+	parts := []string{}
+	lines := []string{}
+	size := 0
+	fence := ""
+
+	flush := func() {
+		if fence != "" && lines[len(lines)-1] == fence {
+			// the code block is empty in this part, open it in the next one only
+			lines = lines[:len(lines)-1]
+		} else if fence != "" {
+			lines = append(lines, "```")
+		}
+		if len(lines) > 0 {
+			parts = append(parts, strings.Join(lines, "\n"))
+		}
+		lines = []string{}
+		size = 0
+		if fence != "" {
+			lines = append(lines, fence)
+			size = utf8.RuneCountInString(fence) + 1
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		// keep room to close the code block, unless the line closes it
+		reserve := fenceRunes(fence)
+		if fence != "" && strings.HasPrefix(strings.TrimSpace(line), "```") {
+			reserve = 0
+		}
+
+		// a piece fits in a part with the reopened fence, its newline and the closing fence
+		for _, piece := range splitLine(line, limit-fenceRunes(fence)*2-1) {
+			n := utf8.RuneCountInString(piece) + 1
+			if size+n+reserve > limit && len(lines) > 0 {
+				flush()
+			}
+			lines = append(lines, piece)
+			size += n
+		}
+
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if fence == "" {
+				fence = strings.TrimSpace(line)
+			} else {
+				fence = ""
+			}
+		}
+	}
+	if len(lines) > 0 {
+		parts = append(parts, strings.Join(lines, "\n"))
+	}
+
+	for i := range parts {
+		parts[i] = fmt.Sprintf("(%d/%d)\n%s", i+1, len(parts), parts[i])
+	}
+	return parts
+}
+
+// splitLine splits a line longer than max runes.
+func splitLine(line string, max int) []string {
+	if max < 1 {
+		max = 1
+	}
+
+	pieces := []string{}
+	runes := []rune(line)
+	for len(runes) > max {
+		pieces = append(pieces, string(runes[:max]))
+		runes = runes[max:]
+	}
+	return append(pieces, string(runes))
+}
+
+func fenceRunes(fence string) int {
+	if fence == "" {
+		return 0
+	}
+	return utf8.RuneCountInString(fence) + 1
+}
@@ -0,0 +1,115 @@
+package mmbot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var partNumber = regexp.MustCompile(`^\(\d+/\d+\)\n`)
+
+// checkParts checks the size of the parts, and that each of them has balanced, non-empty code blocks.
+func checkParts(t *testing.T, text string, max int) []string {
+	t.Helper()
+
+	parts := splitMessage(text, max)
+	for i, part := range parts {
+		if n := utf8.RuneCountInString(part); n > max {
+			t.Errorf("part %d has %d runes, more than %d", i+1, n, max)
+		}
+		if prefix := fmt.Sprintf("(%d/%d)\n", i+1, len(parts)); !strings.HasPrefix(part, prefix) {
+			t.Errorf("part %d does not start with %q: %q", i+1, prefix, part)
+		}
+
+		lines := strings.Split(partNumber.ReplaceAllString(part, ""), "\n")
+		fence := ""
+		for j, line := range lines {
+			if !strings.HasPrefix(strings.TrimSpace(line), "```") {
+				continue
+			}
+			if fence == "" {
+				fence = line
+				if j+1 < len(lines) && strings.TrimSpace(lines[j+1]) == "```" {
+					t.Errorf("part %d has an empty code block: %q", i+1, part)
+				}
+			} else {
+				fence = ""
+			}
+		}
+		if fence != "" {
+			t.Errorf("part %d leaves the code block open: %q", i+1, part)
+		}
+	}
+	return parts
+}
+
+func TestSplitMessageShort(t *testing.T) {
+	if parts := splitMessage("hello\nworld", 100); len(parts) != 1 || parts[0] != "hello\nworld" {
+		t.Fatalf("expected the text unchanged, got %q", parts)
+	}
+}
+
+func TestSplitMessageLines(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 50; i++ {
+		lines = append(lines, fmt.Sprintf("line %02d", i))
+	}
+	text := strings.Join(lines, "\n")
+
+	parts := checkParts(t, text, 60)
+	if len(parts) < 2 {
+		t.Fatalf("expected several parts, got %d", len(parts))
+	}
+
+	// the lines are kept whole and in order
+	joined := []string{}
+	for _, part := range parts {
+		joined = append(joined, partNumber.ReplaceAllString(part, ""))
+	}
+	if strings.Join(joined, "\n") != text {
+		t.Fatalf("the parts do not add up to the text: %q", parts)
+	}
+}
+
+func TestSplitMessageLongLine(t *testing.T) {
+	text := strings.Repeat("日本語", 100)
+	parts := checkParts(t, text, 50)
+
+	joined := ""
+	for _, part := range parts {
+		joined += partNumber.ReplaceAllString(part, "")
+	}
+	if joined != text {
+		t.Fatalf("the parts do not add up to the text: %q", parts)
+	}
+}
+
+func TestSplitMessageCodeBlock(t *testing.T) {
+	lines := []string{"before", "```go"}
+	for i := 0; i < 40; i++ {
+		lines = append(lines, fmt.Sprintf("fmt.Println(%d)", i))
+	}
+	lines = append(lines, "```", "after")
+
+	parts := checkParts(t, strings.Join(lines, "\n"), 80)
+	for i, part := range parts[1 : len(parts)-1] {
+		if !strings.Contains(part, "\n```go\n") {
+			t.Errorf("part %d does not reopen the code block: %q", i+2, part)
+		}
+	}
+}
+
+func TestSplitMessageFenceBoundaries(t *testing.T) {
+	// the fences fall on every position of the part boundaries
+	for max := 30; max < 60; max++ {
+		for pad := 0; pad < 20; pad++ {
+			lines := []string{strings.Repeat("x", pad)}
+			for i := 0; i < 5; i++ {
+				lines = append(lines, "```", "code", strings.Repeat("y", pad), "```", "text")
+			}
+			checkParts(t, strings.Join(lines, "\n"), max)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package mmbot
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/mattermost/platform/model"
+)
+
+// TriggerConfig decides which messages are addressed to the bot.
+// The rules of a channel, by its name, override the fields they set.
+type TriggerConfig struct {
+	TriggerRule `yaml:",inline"`
+	Channels    map[string]TriggerRule `yaml:"channels"`
+}
+
+type TriggerRule struct {
+	// names and aliases of the bot besides its username, with or without @
+	Names []string `yaml:"names"`
+	// short prefixes of commands, such as "!"
+	Prefixes []string `yaml:"prefixes"`
+	// match the names case-insensitively
+	IgnoreCase *bool `yaml:"ignore_case"`
+	// accept an @mention of the bot anywhere in the message
+	MentionAnywhere *bool `yaml:"mention_anywhere"`
+}
+
+// For returns the rule of the channel.
+func (c TriggerConfig) For(channelName string) TriggerRule {
+	rule := c.TriggerRule
+	override, ok := c.Channels[channelName]
+	if !ok {
+		return rule
+	}
+
+	if override.Names != nil {
+		rule.Names = override.Names
+	}
+	if override.Prefixes != nil {
+		rule.Prefixes = override.Prefixes
+	}
+	if override.IgnoreCase != nil {
+		rule.IgnoreCase = override.IgnoreCase
+	}
+	if override.MentionAnywhere != nil {
+		rule.MentionAnywhere = override.MentionAnywhere
+	}
+	return rule
+}
+
+// commandText returns the text of the command if the message is addressed to the bot.
+// The text is the same whichever trigger was used.
+func (b *BotKit) commandText(message string, channel *model.Channel) (string, bool) {
+	rule := b.Config().Triggers.For(channel.Name)
+	ignoreCase := rule.IgnoreCase != nil && *rule.IgnoreCase
+
+	names := append([]string{b.User.Username}, rule.Names...)
+	triggers := []string{}
+	for _, name := range names {
+		name = strings.TrimPrefix(name, "@")
+		triggers = append(triggers, name, "@"+name)
+	}
+
+	for _, trigger := range triggers {
+		if hasPrefix(message, trigger, ignoreCase) {
+			rest := message[len(trigger):]
+			// "botty" is not "bot"
+			if rest != "" && !strings.ContainsAny(rest[:1], " \t\n:,") {
+				continue
+			}
+			return strings.TrimSpace(strings.TrimLeft(rest, ":,")), true
+		}
+	}
+
+	for _, prefix := range rule.Prefixes {
+		if prefix != "" && strings.HasPrefix(message, prefix) {
+			return strings.TrimSpace(message[len(prefix):]), true
+		}
+	}
+
+	if rule.MentionAnywhere != nil && *rule.MentionAnywhere {
+		for _, name := range names {
+			if text, ok := b.mentions.remove(message, strings.TrimPrefix(name, "@"), ignoreCase); ok {
+				return text, true
+			}
+		}
+	}
+
+	// every message in a direct or group message is addressed to the bot
+	if channel.IsGroupOrDirect() {
+		return strings.TrimSpace(message), true
+	}
+	return "", false
+}
+
+func hasPrefix(s, prefix string, ignoreCase bool) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	if ignoreCase {
+		return strings.EqualFold(s[:len(prefix)], prefix)
+	}
+	return s[:len(prefix)] == prefix
+}
+
+// mentionPatterns keeps the compiled patterns of the mentions, so that they are not compiled for every message.
+type mentionPatterns struct {
+	mu       sync.Mutex
+	patterns map[mentionKey]*regexp.Regexp
+}
+
+type mentionKey struct {
+	name       string
+	ignoreCase bool
+}
+
+func (m *mentionPatterns) pattern(name string, ignoreCase bool) *regexp.Regexp {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := mentionKey{name, ignoreCase}
+	if re, ok := m.patterns[key]; ok {
+		return re
+	}
+
+	// usernames consist of letters, digits, '.', '-' and '_'
+	pattern := `(^|\s)@` + regexp.QuoteMeta(name) + `[:,]?(\s|$)`
+	if ignoreCase {
+		pattern = `(?i)` + pattern
+	}
+
+	if m.patterns == nil {
+		m.patterns = map[mentionKey]*regexp.Regexp{}
+	}
+	m.patterns[key] = regexp.MustCompile(pattern)
+	return m.patterns[key]
+}
+
+// remove removes the @mention of the name from the message.
+func (m *mentionPatterns) remove(message, name string, ignoreCase bool) (string, bool) {
+	re := m.pattern(name, ignoreCase)
+	if !re.MatchString(message) {
+		return "", false
+	}
+	return strings.TrimSpace(re.ReplaceAllString(message, " ")), true
+}
@@ -0,0 +1,65 @@
+package mmbot
+
+import (
+	"testing"
+
+	"github.com/mattermost/platform/model"
+)
+
+func TestCommandText(t *testing.T) {
+	b, _ := newTestBot(t)
+	yes := true
+	setTestConfig(b, func(config *Config) {
+		config.Triggers = TriggerConfig{
+			TriggerRule: TriggerRule{Names: []string{"@robo"}, Prefixes: []string{"!"}},
+			Channels: map[string]TriggerRule{
+				"random":    {Prefixes: []string{}, IgnoreCase: &yes, MentionAnywhere: &yes},
+				"off-topic": {Names: []string{"helper"}},
+			},
+		}
+	})
+
+	townSquare := &model.Channel{Name: "town-square", Type: model.CHANNEL_OPEN}
+	random := &model.Channel{Name: "random", Type: model.CHANNEL_OPEN}
+	offTopic := &model.Channel{Name: "off-topic", Type: model.CHANNEL_OPEN}
+	direct := &model.Channel{Name: "direct", Type: model.CHANNEL_DIRECT}
+
+	tests := []struct {
+		message string
+		channel *model.Channel
+		text    string
+		ok      bool
+	}{
+		{"bot ping", townSquare, "ping", true},
+		{"@bot: ping", townSquare, "ping", true},
+		{"robo, ping", townSquare, "ping", true},
+		{"@robo ping", townSquare, "ping", true},
+		{"!ping", townSquare, "ping", true},
+		{"botty ping", townSquare, "", false},
+		{"BOT ping", townSquare, "", false},
+		{"hey @bot ping", townSquare, "", false},
+		{"ping", townSquare, "", false},
+
+		// the channel ignores the case, accepts mentions anywhere and has no prefixes
+		{"BOT ping", random, "ping", true},
+		{"hey @Bot ping", random, "hey ping", true},
+		{"please ping @robo", random, "please ping", true},
+		{"!ping", random, "", false},
+		{"hey @botty ping", random, "", false},
+
+		// the names of the channel replace the others
+		{"helper ping", offTopic, "ping", true},
+		{"robo ping", offTopic, "", false},
+		{"bot ping", offTopic, "ping", true},
+		{"!ping", offTopic, "ping", true},
+
+		{" ping ", direct, "ping", true},
+	}
+
+	for _, test := range tests {
+		text, ok := b.commandText(test.message, test.channel)
+		if text != test.text || ok != test.ok {
+			t.Errorf("%q in %s: expected %q, %v, got %q, %v", test.message, test.channel.Name, test.text, test.ok, text, ok)
+		}
+	}
+}